@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/etherealmachine/pilot/discovery"
+	"github.com/etherealmachine/pilot/upnp"
+)
+
+// deviceType is the UPnP device type of pilot, which other pilots search for
+// over SSDP.
+const deviceType = "urn:schemas-etherealmachine-com:device:Pilot:1"
+
+// descriptionPath is where the UPnP device description is served.
+const descriptionPath = "/upnp/description.xml"
+
+// advertise makes pilot discoverable on the LAN over mDNS, as a web server,
+// and over SSDP, as a UPnP device.
+func (s *server) advertise() {
+	cfg := s.config()
+	if !cfg.Discovery.Enabled {
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "pilot"
+	}
+	host = strings.Split(host, ".")[0]
+	name := cfg.Discovery.Name
+	if name == "" {
+		name = "Pilot on " + host
+	}
+	s.service = &discovery.Service{
+		Name: name,
+		Host: host,
+		Port: cfg.Port,
+		Text: map[string]string{
+			"path":  "/",
+			"pilot": strings.Trim(apiPrefix, "/"),
+		},
+		UUID:     discovery.UUID(host + ":" + strconv.Itoa(cfg.Port)),
+		Location: descriptionPath,
+		Types:    []string{deviceType},
+	}
+	if cfg.Discovery.MediaServer {
+		s.service.Types = append(s.service.Types, upnp.MediaServer, upnp.ContentDirectory, upnp.ConnectionManager)
+	}
+	if cfg.Discovery.Interface != "" {
+		iface, err := net.InterfaceByName(cfg.Discovery.Interface)
+		if err != nil {
+			log.Printf("error advertising pilot: %v", err)
+			return
+		}
+		s.service.Interface = iface
+	}
+	if m, err := discovery.AdvertiseMDNS(s.service); err != nil {
+		log.Printf("error advertising pilot over mdns: %v", err)
+	} else {
+		s.adverts = append(s.adverts, m)
+	}
+	if a, err := discovery.AdvertiseSSDP(s.service); err != nil {
+		log.Printf("error advertising pilot over ssdp: %v", err)
+	} else {
+		s.adverts = append(s.adverts, a)
+	}
+	log.Printf("advertising pilot as %q", name)
+}
+
+// DescriptionHandler serves the UPnP description of pilot, which SSDP
+// advertisements point to. As a MediaServer, pilot describes itself as one
+// so TVs list it.
+func (s *server) DescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if s.service == nil {
+		http.NotFound(w, r)
+		return
+	}
+	desc := upnp.Root{
+		SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
+		Device: upnp.Device{
+			DeviceType:      deviceType,
+			FriendlyName:    s.service.Name,
+			Manufacturer:    "etherealmachine",
+			ModelName:       "pilot",
+			UDN:             "uuid:" + s.service.UUID,
+			PresentationURL: "/",
+		},
+	}
+	if s.config().Discovery.MediaServer {
+		desc.Device.DeviceType = upnp.MediaServer
+		desc.Device.ServiceList = &upnp.ServiceList{Services: mediaServices()}
+	}
+	serveXML(w, desc)
+}
+
+// serveXML writes v as an XML document.
+func serveXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// shutdown withdraws the adverts of pilot and stops the cvlc it runs.
+func (s *server) shutdown() {
+	for _, a := range s.adverts {
+		if err := a.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	if s.cvlc != nil {
+		s.cvlc.Stop()
+	}
+}
+
+// stopOnExit shuts down and exits when pilot gets SIGINT or SIGTERM.
+func (s *server) stopOnExit() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	log.Printf("got %v, shutting down", <-c)
+	s.shutdown()
+	os.Exit(0)
+}
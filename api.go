@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/etherealmachine/pilot/library"
+	"github.com/etherealmachine/pilot/player"
+)
+
+const apiPrefix = "/api/v1/"
+
+var errNotFound = errors.New("not found")
+
+// PlayerStatus is the state of the player of a device, as reported by the API.
+type PlayerStatus struct {
+	Device         string         `json:"device"`
+	Online         bool           `json:"online"`
+	State          string         `json:"state"`
+	File           string         `json:"file,omitempty"`
+	Title          string         `json:"title,omitempty"`
+	Time           int            `json:"time"`
+	Length         int            `json:"length"`
+	Position       float64        `json:"position"`
+	Volume         int            `json:"volume"`
+	Rate           float64        `json:"rate"`
+	Chapter        int            `json:"chapter"`
+	Chapters       int            `json:"chapters"`
+	AudioTracks    []player.Track `json:"audioTracks"`
+	SubtitleTracks []player.Track `json:"subtitleTracks"`
+	VideoTracks    []player.Track `json:"videoTracks"`
+}
+
+// PlaylistItem is an entry in the playlist of the player of a device.
+type PlaylistItem struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	File     string `json:"file,omitempty"`
+	Duration int    `json:"duration"`
+	Current  bool   `json:"current"`
+}
+
+// apiRequest holds the parameters of an API call, sent either as a JSON body
+// or as form values.
+type apiRequest struct {
+	File    string   `json:"file"`
+	Value   string   `json:"value"`
+	ID      int      `json:"id"`
+	Play    bool     `json:"play"`
+	Time    int      `json:"time"`
+	Length  int      `json:"length"`
+	Devices []string `json:"devices"`
+}
+
+func parseAPIRequest(r *http.Request) (*apiRequest, error) {
+	req := new(apiRequest)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, fmt.Errorf("error decoding request: %v", err)
+		}
+		return req, nil
+	}
+	req.File = r.FormValue("file")
+	req.Value = r.FormValue("value")
+	req.Play = r.FormValue("play") == "true"
+	req.Devices = r.Form["devices"]
+	for name, v := range map[string]*int{"id": &req.ID, "time": &req.Time, "length": &req.Length} {
+		if value := r.FormValue(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*v = n
+		}
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	})
+}
+
+// APIHandler serves the JSON API under /api/v1/.
+func (s *server) APIHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	var (
+		v   interface{}
+		err error
+	)
+	switch {
+	case path[0] == "library" && r.Method == http.MethodGet:
+		v, err = s.apiLibrary(r, path[1:])
+	case path[0] == "search" && r.Method == http.MethodGet:
+		v, err = s.apiSearch(r)
+	case path[0] == "devices" && len(path) == 1 && r.Method == http.MethodGet:
+		v = s.deviceStates()
+	case path[0] == "groups":
+		v, err = s.apiGroups(r, path[1:])
+	case path[0] == "status" && r.Method == http.MethodGet:
+		v, err = s.withDevice(r, s.apiStatus)
+	case path[0] == "playlist":
+		v, err = s.withDevice(r, func(d *device) (interface{}, error) {
+			return s.apiPlaylist(d, r, path[1:])
+		})
+	case path[0] == "playlists" && len(path) == 3 && path[2] == "export" && r.Method == http.MethodGet:
+		s.exportPlaylist(w, r, path[1])
+		return
+	case path[0] == "playlists":
+		v, err = s.apiPlaylists(r, path[1:])
+	case path[0] == "queue":
+		v, err = s.withDevice(r, func(d *device) (interface{}, error) {
+			return s.apiQueue(d, r, path[1:])
+		})
+	case path[0] == "progress":
+		v, err = s.apiProgress(r)
+	case path[0] == "watched" && r.Method == http.MethodPost:
+		v, err = s.apiWatched(r)
+	case path[0] == "player" && len(path) == 2 && r.Method == http.MethodPost:
+		v, err = s.withDevice(r, func(d *device) (interface{}, error) {
+			return s.apiPlayer(d, r, path[1])
+		})
+	default:
+		err = errNotFound
+	}
+	switch {
+	case err == errNotFound:
+		apiError(w, http.StatusNotFound, err)
+	case errors.Is(err, errOffline):
+		apiError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			apiError(w, http.StatusBadRequest, err)
+		} else {
+			apiError(w, http.StatusBadGateway, err)
+		}
+	default:
+		writeJSON(w, v)
+	}
+}
+
+// withDevice calls f with the device the request is for.
+func (s *server) withDevice(r *http.Request, f func(d *device) (interface{}, error)) (interface{}, error) {
+	d, err := s.requestDevice(r)
+	if err != nil {
+		return nil, err
+	}
+	return f(d)
+}
+
+// requestError is returned for API calls with missing or invalid parameters.
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{fmt.Sprintf(format, args...)}
+}
+
+// checkFile returns a requestError unless file is in the library.
+func (s *server) checkFile(file string) error {
+	if file == "" {
+		return badRequest("missing file")
+	}
+	if s.Library.Get(file) == nil {
+		return badRequest("%q is not in the library", file)
+	}
+	return nil
+}
+
+func (s *server) apiLibrary(r *http.Request, path []string) (interface{}, error) {
+	var files []library.File
+	folder := r.FormValue("folder")
+	query := strings.ToLower(r.FormValue("q"))
+	for _, f := range s.Library.List() {
+		if folder != "" && !strings.HasPrefix(f.Path, folder+"/") {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(f.Path), query) {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(path) == 0 {
+		return &struct {
+			Modified int64          `json:"modified"`
+			Files    []library.File `json:"files"`
+		}{
+			Modified: unixMillis(s.Library.LastModified()),
+			Files:    files,
+		}, nil
+	}
+	switch path[0] {
+	case "movies":
+		movies := &library.Library{Paths: s.kindFolders(library.MoviesKind)}
+		return library.Movies(movies.Files(files)), nil
+	case "shows":
+		return library.Shows(s.kindFolders(library.ShowsKind), files), nil
+	case "libraries":
+		if len(path) == 1 {
+			return s.libraries(), nil
+		}
+		l := s.findLibrary(path[1])
+		if l == nil {
+			return nil, errNotFound
+		}
+		return s.libraryContents(l), nil
+	}
+	return nil, errNotFound
+}
+
+func (s *server) apiStatus(d *device) (interface{}, error) {
+	if !d.Monitor.Online() {
+		return d.Monitor.Status(), nil
+	}
+	status, err := d.player().Status()
+	if err != nil {
+		return nil, err
+	}
+	ps := s.playerStatus(d, status)
+	if status.Current >= 0 {
+		items, err := s.playlistItems(d)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.ID == status.Current {
+				ps.File = item.File
+			}
+		}
+	}
+	return ps, nil
+}
+
+func (s *server) playerStatus(d *device, status *player.Status) *PlayerStatus {
+	ps := &PlayerStatus{
+		Device:         d.Name,
+		Online:         true,
+		State:          status.State,
+		Title:          status.Title,
+		Time:           status.Time,
+		Length:         status.Length,
+		Position:       status.Position,
+		Volume:         status.Volume,
+		Rate:           status.Rate,
+		Chapter:        status.Chapter,
+		Chapters:       status.Chapters,
+		AudioTracks:    status.AudioTracks,
+		SubtitleTracks: status.SubtitleTracks,
+		VideoTracks:    status.VideoTracks,
+	}
+	if ps.Title == "" && status.Filename != "" {
+		ps.Title = titleize(status.Filename)
+	}
+	return ps
+}
+
+// libraryPath returns the path relative to the library root of a file or
+// file:// URI the player reported, or an empty string if it isn't in the library.
+func (s *server) libraryPath(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme == "file" {
+		name = u.Path
+	}
+	if !filepath.IsAbs(name) {
+		return ""
+	}
+	base, err := filepath.Abs(s.Library.Root)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(base, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return rel
+}
+
+func (s *server) apiPlaylist(d *device, r *http.Request, path []string) (interface{}, error) {
+	if !d.Monitor.Online() {
+		return nil, errOffline
+	}
+	switch {
+	case len(path) == 0 && r.Method == http.MethodGet:
+		return s.playlistItems(d)
+	case len(path) == 0 && r.Method == http.MethodPost:
+		req, err := parseAPIRequest(r)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		if err := d.player().Load(s.item(req.File), req.Play); err != nil {
+			return nil, err
+		}
+		return s.playlistItems(d)
+	case len(path) == 0 && r.Method == http.MethodDelete:
+		if err := d.player().Clear(); err != nil {
+			return nil, err
+		}
+		return s.playlistItems(d)
+	}
+	if len(path) == 0 {
+		return nil, errNotFound
+	}
+	id, err := strconv.Atoi(path[0])
+	if err != nil {
+		return nil, errNotFound
+	}
+	switch {
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		err = d.player().Remove(id)
+	case len(path) == 2 && path[1] == "play" && r.Method == http.MethodPost:
+		err = d.player().PlayEntry(id)
+	default:
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.playlistItems(d)
+}
+
+func (s *server) playlistItems(d *device) ([]PlaylistItem, error) {
+	entries, err := d.player().Playlist()
+	if err != nil {
+		return nil, err
+	}
+	items := []PlaylistItem{}
+	for _, e := range entries {
+		items = append(items, PlaylistItem{
+			ID:       e.ID,
+			Name:     e.Title,
+			URI:      e.URL,
+			File:     s.libraryPath(e.URL),
+			Duration: e.Duration,
+			Current:  e.Current,
+		})
+	}
+	return items, nil
+}
+
+func (s *server) apiPlayer(d *device, r *http.Request, command string) (interface{}, error) {
+	req, err := parseAPIRequest(r)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if !d.Monitor.Online() {
+		return nil, errOffline
+	}
+	switch command {
+	case "cast":
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		err = s.PlayOnTV(d, req.File, req.Time)
+	case "play":
+		err = d.player().Play()
+	case "pause":
+		err = d.player().Pause()
+	case "toggle":
+		err = d.player().Toggle()
+	case "stop":
+		err = d.player().Stop()
+	case "stop-after":
+		err = s.StopAfterCurrent(d)
+	case "next":
+		err = d.player().Next()
+	case "previous":
+		// The player only holds the queue from the current item on.
+		if _, current := d.Queue.List(); current > 0 {
+			err = s.PlayQueueItem(d, current-1)
+		} else {
+			err = d.player().Previous()
+		}
+	case "seek", "volume":
+		if req.Value == "" {
+			return nil, badRequest("missing value")
+		}
+		n, relative, convErr := player.ParseValue(req.Value)
+		if convErr != nil {
+			return nil, badRequest("invalid value %q", req.Value)
+		}
+		if command == "seek" {
+			err = d.player().Seek(n, relative)
+		} else {
+			err = d.player().SetVolume(n, relative)
+		}
+	case "audio-track":
+		err = d.player().SelectAudioTrack(req.ID)
+	case "subtitle-track":
+		err = d.player().SelectSubtitleTrack(req.ID)
+	case "video-track":
+		err = d.player().SelectVideoTrack(req.ID)
+	case "chapter":
+		err = d.player().SelectChapter(req.ID)
+	default:
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.apiStatus(d)
+}
+
+func (s *server) apiProgress(r *http.Request) (interface{}, error) {
+	req, err := parseAPIRequest(r)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if req.File == "" {
+		return nil, badRequest("missing file")
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		if err := s.Progress.Update(req.File, req.Time, req.Length); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errNotFound
+	}
+	progress := s.Progress.Get(req.File)
+	if progress == nil {
+		progress = new(library.Progress)
+	}
+	return &struct {
+		*library.Progress
+		Resume int `json:"resume"`
+	}{progress, progress.Resume()}, nil
+}
+
+func (s *server) apiWatched(r *http.Request) (interface{}, error) {
+	req, err := parseAPIRequest(r)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if err := s.checkFile(req.File); err != nil {
+		return nil, err
+	}
+	watched, err := strconv.ParseBool(req.Value)
+	if err != nil {
+		return nil, badRequest("invalid value %q", req.Value)
+	}
+	if err := s.Progress.SetWatched(req.File, watched); err != nil {
+		return nil, err
+	}
+	return s.Progress.Get(req.File), nil
+}
@@ -18,6 +18,10 @@ const (
 	Stop
 	FastForward
 	Rewind
+	Red
+	Green
+	Yellow
+	Blue
 )
 
 type regexEvent struct {
@@ -46,6 +50,22 @@ var events = []*regexEvent{
 		regexp.MustCompile(`key pressed: rewind \([0-9]+, [0-9]+\)`),
 		Rewind,
 	},
+	{
+		regexp.MustCompile(`key pressed: F2 \(red\) \([0-9]+, [0-9]+\)`),
+		Red,
+	},
+	{
+		regexp.MustCompile(`key pressed: F3 \(green\) \([0-9]+, [0-9]+\)`),
+		Green,
+	},
+	{
+		regexp.MustCompile(`key pressed: F4 \(yellow\) \([0-9]+, [0-9]+\)`),
+		Yellow,
+	},
+	{
+		regexp.MustCompile(`key pressed: F1 \(blue\) \([0-9]+, [0-9]+\)`),
+		Blue,
+	},
 }
 
 //export logMessageCallback
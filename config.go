@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/etherealmachine/pilot/library"
+	"github.com/sirupsen/logrus"
+)
+
+// Config holds every setting of pilot. It is read from the JSON file given
+// with -config, on top of the command-line defaults; flags set explicitly win
+// over the file.
+//
+// Devices are the TVs pilot plays on. Without any, there is a single one
+// called TV, set up by Player, VLC, MPV, DLNA and CEC.
+type Config struct {
+	Root      string             `json:"root"`
+	Port      int                `json:"port"`
+	Password  string             `json:"password"`
+	Binge     int                `json:"binge"`
+	Libraries []*library.Library `json:"libraries"`
+	Player    string             `json:"player"`
+	VLC       VLCConfig          `json:"vlc"`
+	MPV       MPVConfig          `json:"mpv"`
+	DLNA      DLNAConfig         `json:"dlna"`
+	CEC       CECConfig          `json:"cec"`
+	Log       LogConfig          `json:"log"`
+	Files     FilesConfig        `json:"files"`
+	Discovery DiscoveryConfig    `json:"discovery"`
+	Devices   []*DeviceConfig    `json:"devices"`
+
+	// devices is the devices section as written in the file, before the
+	// top-level settings fill in what it leaves out.
+	devices []json.RawMessage
+}
+
+// DeviceConfig is a TV to play on. Settings it leaves out are taken from the
+// top-level player, vlc, mpv and dlna; the first device also takes the vlc
+// spawn and cec settings, as they are about the machine pilot runs on.
+type DeviceConfig struct {
+	Name   string     `json:"name"`
+	Player string     `json:"player"`
+	VLC    VLCConfig  `json:"vlc"`
+	MPV    MPVConfig  `json:"mpv"`
+	DLNA   DLNAConfig `json:"dlna"`
+	CEC    CECConfig  `json:"cec"`
+}
+
+// VLCConfig is where to reach VLC's HTTP interface. With Spawn set, pilot
+// runs Command itself on Display, with a random password instead of Password.
+type VLCConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Password string `json:"password"`
+	Spawn    bool   `json:"spawn"`
+	Command  string `json:"command"`
+	Display  string `json:"display"`
+}
+
+// MPVConfig is where to reach mpv's JSON IPC, the socket given to mpv with
+// --input-ipc-server.
+type MPVConfig struct {
+	Socket string `json:"socket"`
+}
+
+// DLNAConfig is the DLNA renderer to play on: its friendly name or UDN, the
+// URL of its description, or empty for the first one found over SSDP.
+type DLNAConfig struct {
+	Renderer string `json:"renderer"`
+}
+
+// CECConfig sets up the HDMI-CEC adapter for the TV remote, whose keys
+// control the device the adapter belongs to. An empty adapter uses the first
+// one found.
+type CECConfig struct {
+	Enabled bool   `json:"enabled"`
+	Adapter string `json:"adapter"`
+	Name    string `json:"name"`
+}
+
+// LogConfig says where logs go and how verbose they are. An empty Dir logs
+// to stderr.
+type LogConfig struct {
+	Dir   string `json:"dir"`
+	Level string `json:"level"`
+}
+
+// FilesConfig lists the files pilot keeps its state in.
+type FilesConfig struct {
+	Index     string `json:"index"`
+	Progress  string `json:"progress"`
+	Queue     string `json:"queue"`
+	Playlists string `json:"playlists"`
+}
+
+// DiscoveryConfig sets up how pilot advertises itself on the LAN, over mDNS
+// and SSDP, which it only does when Enabled. An empty Name is "Pilot on" the
+// host name, an empty Interface the system's default one. With MediaServer
+// set, pilot is also a UPnP MediaServer that anyone on the LAN can browse the
+// library of, password or not, so it's off unless asked for.
+type DiscoveryConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Name        string `json:"name"`
+	Interface   string `json:"interface"`
+	MediaServer bool   `json:"mediaserver"`
+}
+
+// flagConfig returns the configuration given by the command-line flags.
+func flagConfig() (*Config, error) {
+	libraries, err := library.ParseLibraries(*folders)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Root:      *root,
+		Port:      *port,
+		Password:  *password,
+		Binge:     *binge,
+		Libraries: libraries,
+		Player:    *playerName,
+		VLC: VLCConfig{
+			Host:     "127.0.0.1",
+			Port:     8081,
+			Password: "raspberry",
+			Spawn:    *spawnVLC,
+			Command:  "cvlc",
+			Display:  ":0",
+		},
+		MPV: MPVConfig{Socket: "/tmp/mpvsocket"},
+		CEC: CECConfig{Enabled: true, Name: "pilot"},
+		Log: LogConfig{Dir: *logdir, Level: "debug"},
+		Files: FilesConfig{
+			Index:     *index,
+			Progress:  *progressFile,
+			Queue:     *queueFile,
+			Playlists: *playlistFile,
+		},
+	}, nil
+}
+
+// loadConfig reads the configuration from filename, if given, and checks it.
+func loadConfig(filename string) (*Config, error) {
+	cfg, err := flagConfig()
+	if err != nil {
+		return nil, err
+	}
+	if filename != "" {
+		fromFlags := *cfg
+		if err := cfg.read(filename); err != nil {
+			return nil, err
+		}
+		// Flags given on the command line take precedence over the file.
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "root":
+				cfg.Root = fromFlags.Root
+			case "port":
+				cfg.Port = fromFlags.Port
+			case "password":
+				cfg.Password = fromFlags.Password
+			case "binge":
+				cfg.Binge = fromFlags.Binge
+			case "player":
+				cfg.Player = fromFlags.Player
+			case "spawn-vlc":
+				cfg.VLC.Spawn = fromFlags.VLC.Spawn
+			case "folders":
+				cfg.Libraries = fromFlags.Libraries
+			case "logdir":
+				cfg.Log.Dir = fromFlags.Log.Dir
+			case "index":
+				cfg.Files.Index = fromFlags.Files.Index
+			case "progress":
+				cfg.Files.Progress = fromFlags.Files.Progress
+			case "queue":
+				cfg.Files.Queue = fromFlags.Files.Queue
+			case "playlists":
+				cfg.Files.Playlists = fromFlags.Files.Playlists
+			}
+		})
+	}
+	if err := cfg.setDevices(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		if filename != "" {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) read(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			line, col := position(data, syntaxErr.Offset)
+			return fmt.Errorf("%s:%d:%d: %v", filename, line, col, err)
+		case errors.As(err, &typeErr):
+			line, col := position(data, typeErr.Offset)
+			return fmt.Errorf("%s:%d:%d: %s must be a %s, not %s", filename, line, col, typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	var raw struct {
+		Devices []json.RawMessage `json:"devices"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	cfg.devices = raw.Devices
+	return nil
+}
+
+// setDevices fills in Devices from the devices section of the file and the
+// top-level settings.
+func (cfg *Config) setDevices() error {
+	if len(cfg.devices) == 0 {
+		cfg.Devices = []*DeviceConfig{{
+			Name:   "TV",
+			Player: cfg.Player,
+			VLC:    cfg.VLC,
+			MPV:    cfg.MPV,
+			DLNA:   cfg.DLNA,
+			CEC:    cfg.CEC,
+		}}
+		return nil
+	}
+	cfg.Devices = nil
+	for i, data := range cfg.devices {
+		d := &DeviceConfig{Player: cfg.Player, VLC: cfg.VLC, MPV: cfg.MPV, DLNA: cfg.DLNA}
+		d.VLC.Spawn = false
+		if i == 0 {
+			d.VLC.Spawn, d.CEC = cfg.VLC.Spawn, cfg.CEC
+		}
+		if err := json.Unmarshal(data, d); err != nil {
+			return fmt.Errorf("devices[%d]: %v", i, err)
+		}
+		cfg.Devices = append(cfg.Devices, d)
+	}
+	return nil
+}
+
+// spawned returns the device whose VLC pilot runs itself, or nil.
+func (cfg *Config) spawned() *DeviceConfig {
+	for _, d := range cfg.Devices {
+		if d.VLC.Spawn {
+			return d
+		}
+	}
+	return nil
+}
+
+// position returns the line and column of offset in data.
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, int(offset) - bytes.LastIndexByte(before, '\n') - 1
+}
+
+func (cfg *Config) validate() error {
+	if info, err := os.Stat(cfg.Root); err != nil {
+		return fmt.Errorf("root: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("root: %s is not a directory", cfg.Root)
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("port: %d is not a valid port", cfg.Port)
+	}
+	if cfg.Binge < 0 {
+		return fmt.Errorf("binge: must not be negative, got %d", cfg.Binge)
+	}
+	if len(cfg.Libraries) == 0 {
+		return errors.New("libraries: at least one library is needed")
+	}
+	names := make(map[string]bool)
+	for i, l := range cfg.Libraries {
+		if l == nil {
+			return fmt.Errorf("libraries[%d]: empty library", i)
+		}
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("libraries[%d]: %v", i, err)
+		}
+		if names[strings.ToLower(l.Name)] {
+			return fmt.Errorf("libraries[%d]: library %q is defined twice", i, l.Name)
+		}
+		names[strings.ToLower(l.Name)] = true
+	}
+	devices := make(map[string]bool)
+	adapters := make(map[string]bool)
+	var spawned string
+	for i, d := range cfg.Devices {
+		// A device made from the top-level settings is reported under them.
+		prefix := ""
+		if len(cfg.devices) > 0 {
+			prefix = fmt.Sprintf("devices[%d].", i)
+		}
+		if d.Name == "" {
+			return fmt.Errorf("%sname: missing", prefix)
+		}
+		if devices[strings.ToLower(d.Name)] {
+			return fmt.Errorf("%sname: device %q is defined twice", prefix, d.Name)
+		}
+		devices[strings.ToLower(d.Name)] = true
+		if err := d.validate(prefix); err != nil {
+			return err
+		}
+		if d.VLC.Spawn {
+			if spawned != "" {
+				return fmt.Errorf("%svlc.spawn: pilot already runs the vlc of %s", prefix, spawned)
+			}
+			spawned = d.Name
+		}
+		if d.CEC.Enabled {
+			// The first adapter found can only stand in for the only one.
+			if len(adapters) > 0 && (d.CEC.Adapter == "" || adapters[""]) {
+				return fmt.Errorf("%scec.adapter: every remote needs its adapter set when there are several", prefix)
+			}
+			if adapters[d.CEC.Adapter] {
+				return fmt.Errorf("%scec.adapter: %s is already used", prefix, d.CEC.Adapter)
+			}
+			adapters[d.CEC.Adapter] = true
+		}
+	}
+	if cfg.Discovery.Enabled && cfg.Discovery.Interface != "" {
+		if _, err := net.InterfaceByName(cfg.Discovery.Interface); err != nil {
+			return fmt.Errorf("discovery.interface: %v", err)
+		}
+	}
+	if _, err := logrus.ParseLevel(cfg.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %v", err)
+	}
+	for name, file := range map[string]string{
+		"index":     cfg.Files.Index,
+		"progress":  cfg.Files.Progress,
+		"queue":     cfg.Files.Queue,
+		"playlists": cfg.Files.Playlists,
+	} {
+		if file == "" {
+			return fmt.Errorf("files.%s: missing", name)
+		}
+	}
+	return nil
+}
+
+func (d *DeviceConfig) validate(prefix string) error {
+	switch d.Player {
+	case "vlc":
+	case "mpv":
+		if d.MPV.Socket == "" {
+			return fmt.Errorf("%smpv.socket: missing", prefix)
+		}
+	case "dlna":
+	case "fake":
+	default:
+		return fmt.Errorf("%splayer: unknown player %q, expected vlc, mpv, dlna or fake", prefix, d.Player)
+	}
+	if d.VLC.Spawn && d.Player != "vlc" {
+		return fmt.Errorf("%svlc.spawn: only possible with the vlc player", prefix)
+	}
+	if d.VLC.Host == "" {
+		return fmt.Errorf("%svlc.host: missing", prefix)
+	}
+	if d.VLC.Port <= 0 || d.VLC.Port > 65535 {
+		return fmt.Errorf("%svlc.port: %d is not a valid port", prefix, d.VLC.Port)
+	}
+	if d.VLC.Spawn && d.VLC.Command == "" {
+		return fmt.Errorf("%svlc.command: missing, needed to spawn vlc", prefix)
+	}
+	return nil
+}
+
+// playerChanged reports whether d needs a new player to switch to cfg.
+func (d *DeviceConfig) playerChanged(cfg *DeviceConfig) bool {
+	return cfg.Player != d.Player ||
+		cfg.Player == "vlc" && cfg.VLC != d.VLC ||
+		cfg.Player == "mpv" && cfg.MPV != d.MPV ||
+		cfg.Player == "dlna" && cfg.DLNA != d.DLNA
+}
+
+// config returns the configuration in use.
+func (s *server) config() *Config {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cfg
+}
+
+// libraries returns the configured libraries.
+func (s *server) libraries() []*library.Library {
+	return s.config().Libraries
+}
+
+// reloadOnHangup reads the configuration file again whenever pilot gets a
+// SIGHUP, keeping the old configuration if the new one is invalid.
+func (s *server) reloadOnHangup(filename string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		log.Printf("reloading configuration from %s", filename)
+		cfg, err := loadConfig(filename)
+		if err != nil {
+			log.Printf("error reloading configuration, keeping the old one: %v", err)
+			continue
+		}
+		s.applyConfig(cfg)
+	}
+}
+
+// applyConfig switches to cfg. Settings that are only read at startup keep
+// their old value, with a warning that pilot must be restarted for them.
+func (s *server) applyConfig(cfg *Config) {
+	old := s.config()
+	for name, restart := range map[string]bool{
+		"root":      cfg.Root != old.Root,
+		"port":      cfg.Port != old.Port,
+		"cec":       !sameCEC(cfg.Devices, old.Devices),
+		"log":       cfg.Log.Dir != old.Log.Dir,
+		"files":     cfg.Files != old.Files,
+		"discovery": cfg.Discovery != old.Discovery,
+	} {
+		if restart {
+			log.Printf("%s changed, restart pilot to apply it", name)
+		}
+	}
+	cfg.Root, cfg.Port, cfg.CEC, cfg.Log.Dir, cfg.Files = old.Root, old.Port, old.CEC, old.Log.Dir, old.Files
+	cfg.Discovery = old.Discovery
+	if old.Devices[0].Name != cfg.Devices[0].Name {
+		// The first device's queue is saved to files.queue.
+		log.Println("first device changed, restart pilot to apply the devices")
+		cfg.Devices = old.Devices
+	}
+	if !sameVLC(cfg.spawned(), old.spawned()) {
+		log.Println("player changed, restart pilot to apply it to the vlc it runs")
+		cfg.Devices = old.Devices
+	}
+	for i, d := range cfg.Devices {
+		if prev := findDeviceConfig(old.Devices, d.Name); prev != nil {
+			c := *d
+			c.CEC = prev.CEC
+			cfg.Devices[i] = &c
+		}
+	}
+	level, _ := logrus.ParseLevel(cfg.Log.Level)
+	logrus.SetLevel(level)
+	s.Lock()
+	s.cfg = cfg
+	s.Unlock()
+	s.updateDevices(cfg)
+	if !reflect.DeepEqual(cfg.Libraries, old.Libraries) {
+		log.Println("libraries changed, rescanning")
+		s.Library.SetFolders(library.Folders(cfg.Libraries))
+		go func() {
+			s.reload()
+			s.watch()
+		}()
+	}
+	log.Println("configuration reloaded")
+}
+
+// sameVLC reports whether a and b are the same device with the same vlc.
+func sameVLC(a, b *DeviceConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return strings.EqualFold(a.Name, b.Name) && a.VLC == b.VLC
+}
+
+// sameCEC reports whether the devices in a and b have the same remotes.
+func sameCEC(a, b []*DeviceConfig) bool {
+	for _, d := range a {
+		if prev := findDeviceConfig(b, d.Name); d.CEC.Enabled && (prev == nil || prev.CEC != d.CEC) {
+			return false
+		}
+	}
+	for _, d := range b {
+		if next := findDeviceConfig(a, d.Name); d.CEC.Enabled && (next == nil || next.CEC != d.CEC) {
+			return false
+		}
+	}
+	return true
+}
+
+// findDeviceConfig returns the device called name, ignoring case, or nil.
+func findDeviceConfig(devices []*DeviceConfig, name string) *DeviceConfig {
+	for _, d := range devices {
+		if strings.EqualFold(d.Name, name) {
+			return d
+		}
+	}
+	return nil
+}
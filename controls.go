@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Controls is the JSON-RPC service the Polymer app in app/ posts to /controls.
+// Calls control the device picked in the browser, or the first one.
+type Controls struct {
+	s *server
+}
+
+// ControlsState is the reply to every Controls call. Position and Duration
+// are in milliseconds.
+type ControlsState struct {
+	Playing  string `json:"playing"`
+	Paused   bool   `json:"paused"`
+	CECErr   string `json:"cecErr"`
+	Position int    `json:"position"`
+	Duration int    `json:"duration"`
+	NumFiles int    `json:"num_files,omitempty"`
+}
+
+type NoArgs struct{}
+
+type PlayArgs struct {
+	File string `json:"file"`
+}
+
+type SeekArgs struct {
+	Milliseconds int `json:"milliseconds"`
+}
+
+func (c *Controls) state(d *device, reply *ControlsState) error {
+	status, err := d.player().Status()
+	if err != nil {
+		return err
+	}
+	*reply = ControlsState{
+		Playing:  c.s.CurrentlyPlaying(d),
+		Paused:   status.State == "paused",
+		Position: status.Time * 1000,
+		Duration: status.Length * 1000,
+	}
+	return nil
+}
+
+// Play starts playing args.File on the TV, or resumes playback if no file is given.
+func (c *Controls) Play(r *http.Request, args *PlayArgs, reply *ControlsState) error {
+	d := c.s.currentDevice(r)
+	var err error
+	if args.File != "" {
+		if err := c.s.checkFile(args.File); err != nil {
+			return err
+		}
+		err = c.s.PlayOnTV(d, args.File, 0)
+	} else {
+		err = d.player().Play()
+	}
+	if err != nil {
+		return err
+	}
+	return c.state(d, reply)
+}
+
+// Pause toggles pause.
+func (c *Controls) Pause(r *http.Request, args *NoArgs, reply *ControlsState) error {
+	d := c.s.currentDevice(r)
+	if err := d.player().Toggle(); err != nil {
+		return err
+	}
+	return c.state(d, reply)
+}
+
+// Stop stops playback and empties the queue.
+func (c *Controls) Stop(r *http.Request, args *NoArgs, reply *ControlsState) error {
+	d := c.s.currentDevice(r)
+	if err := c.s.ClearTV(d); err != nil {
+		return err
+	}
+	return c.state(d, reply)
+}
+
+// Seek jumps forward or, for negative values, back by args.Milliseconds.
+func (c *Controls) Seek(r *http.Request, args *SeekArgs, reply *ControlsState) error {
+	if args.Milliseconds == 0 {
+		return errors.New("missing milliseconds")
+	}
+	d := c.s.currentDevice(r)
+	if err := d.player().Seek(args.Milliseconds/1000, true); err != nil {
+		return err
+	}
+	return c.state(d, reply)
+}
+
+// Status returns the current state.
+func (c *Controls) Status(r *http.Request, args *NoArgs, reply *ControlsState) error {
+	return c.state(c.s.currentDevice(r), reply)
+}
+
+// Reload rescans the library and reports how many files it found.
+func (c *Controls) Reload(r *http.Request, args *NoArgs, reply *ControlsState) error {
+	c.s.reload()
+	if err := c.state(c.s.currentDevice(r), reply); err != nil {
+		return err
+	}
+	reply.NumFiles = c.s.Library.Len()
+	return nil
+}
+
+// FilesHandler serves the list of files the Polymer app shows.
+func (s *server) FilesHandler(w http.ResponseWriter, r *http.Request) {
+	files := []string{}
+	for _, f := range s.Library.List() {
+		files = append(files, f.Path)
+	}
+	writeJSON(w, files)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/etherealmachine/pilot/player"
+	"github.com/etherealmachine/pilot/queue"
+)
+
+// device is a TV pilot plays on, with its own player, play queue and monitor.
+type device struct {
+	sync.RWMutex
+	Name    string
+	Monitor *monitor
+	Queue   *queue.Queue
+
+	cfg  *DeviceConfig
+	tv   player.Player
+	stop chan struct{}
+}
+
+// DeviceState is a device as listed by the API.
+type DeviceState struct {
+	Name   string        `json:"name"`
+	Player string        `json:"player"`
+	Status *PlayerStatus `json:"status"`
+}
+
+// newDevice sets up the device cfg describes, with its queue saved next to
+// queueFile.
+func (s *server) newDevice(cfg *DeviceConfig, queueFile string) (*device, error) {
+	q, err := queue.Open(queueFile)
+	if err != nil {
+		return nil, err
+	}
+	d := &device{
+		Name:    cfg.Name,
+		Monitor: newMonitor(cfg.Name),
+		Queue:   q,
+		cfg:     cfg,
+		stop:    make(chan struct{}),
+	}
+	d.tv = s.newPlayer(cfg)
+	return d, nil
+}
+
+// deviceQueueFile returns the file the queue of the device called name is
+// saved to. The first device uses filename itself, so the queue of a single
+// TV stays where it was.
+func deviceQueueFile(filename, name string, first bool) string {
+	if first {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "-" + slugify(name) + ext
+}
+
+// newPlayer returns the player cfg asks for. A VLC that pilot runs itself is
+// reached with the password pilot made up for it.
+func (s *server) newPlayer(cfg *DeviceConfig) player.Player {
+	switch cfg.Player {
+	case "mpv":
+		return player.NewMPV(cfg.MPV.Socket)
+	case "dlna":
+		return player.NewDLNA(cfg.DLNA.Renderer, s.config().Port)
+	case "fake":
+		return player.NewFake()
+	}
+	password := cfg.VLC.Password
+	if cfg.VLC.Spawn && s.cvlc != nil {
+		password = s.cvlc.password
+	}
+	return player.NewVLC(cfg.VLC.Host, cfg.VLC.Port, password)
+}
+
+// player returns the player the device uses.
+func (d *device) player() player.Player {
+	d.RLock()
+	defer d.RUnlock()
+	return d.tv
+}
+
+// setPlayer switches the device to p, set up by cfg. The queue is handed over
+// to it as if the old player had gone offline and this one came back.
+func (d *device) setPlayer(p player.Player, cfg *DeviceConfig) {
+	d.Lock()
+	d.tv, d.cfg = p, cfg
+	d.Unlock()
+	d.Monitor.offline()
+	d.Monitor.Wake()
+}
+
+// config returns the configuration of the device.
+func (d *device) config() *DeviceConfig {
+	d.RLock()
+	defer d.RUnlock()
+	return d.cfg
+}
+
+// deviceList returns every device, the first one being the default.
+func (s *server) deviceList() []*device {
+	s.RLock()
+	defer s.RUnlock()
+	return s.devices
+}
+
+// findDevice returns the device called name, ignoring case, or nil.
+func (s *server) findDevice(name string) *device {
+	for _, d := range s.deviceList() {
+		if strings.EqualFold(d.Name, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+// requestDevice returns the device named by the device parameter of r, or
+// else the one last picked on /cast in this browser, or else the first one.
+func (s *server) requestDevice(r *http.Request) (*device, error) {
+	if name := r.FormValue("device"); name != "" {
+		if d := s.findDevice(name); d != nil {
+			return d, nil
+		}
+		return nil, badRequest("no device called %q", name)
+	}
+	if c, err := r.Cookie("device"); err == nil {
+		if name, err := url.QueryUnescape(c.Value); err == nil {
+			if d := s.findDevice(name); d != nil {
+				return d, nil
+			}
+		}
+	}
+	return s.deviceList()[0], nil
+}
+
+// currentDevice is requestDevice for pages, which fall back to the first
+// device rather than fail.
+func (s *server) currentDevice(r *http.Request) *device {
+	d, err := s.requestDevice(r)
+	if err != nil {
+		return s.deviceList()[0]
+	}
+	return d
+}
+
+// pickDevice makes d the device this browser plays on from now on.
+func pickDevice(w http.ResponseWriter, d *device) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   "device",
+		Value:  url.QueryEscape(d.Name),
+		Path:   "/",
+		MaxAge: 365 * 24 * 60 * 60,
+	})
+}
+
+func (s *server) deviceStates() []DeviceState {
+	var states []DeviceState
+	for _, d := range s.deviceList() {
+		status := d.Monitor.Status()
+		if status == nil {
+			status = &PlayerStatus{Device: d.Name, State: "stopped"}
+		}
+		states = append(states, DeviceState{
+			Name:   d.Name,
+			Player: d.config().Player,
+			Status: status,
+		})
+	}
+	return states
+}
+
+// updateDevices adds, removes and switches the players of the devices to
+// match cfg.
+func (s *server) updateDevices(cfg *Config) {
+	old := s.deviceList()
+	var devices []*device
+	for i, dc := range cfg.Devices {
+		d := s.findDevice(dc.Name)
+		switch {
+		case d == nil:
+			log.Printf("adding device %s", dc.Name)
+			var err error
+			d, err = s.newDevice(dc, deviceQueueFile(cfg.Files.Queue, dc.Name, i == 0))
+			if err != nil {
+				log.Printf("error adding device %s: %v", dc.Name, err)
+				continue
+			}
+			go s.poll(d)
+		case d.config().playerChanged(dc):
+			log.Printf("switching %s to the %s player", d.Name, dc.Player)
+			d.setPlayer(s.newPlayer(dc), dc)
+		default:
+			d.Lock()
+			d.cfg = dc
+			d.Unlock()
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return
+	}
+	s.Lock()
+	s.devices = devices
+	s.Unlock()
+	for _, d := range old {
+		if s.findDevice(d.Name) == nil {
+			log.Printf("removing device %s", d.Name)
+			close(d.stop)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Package discovery announces pilot on the local network: over mDNS/DNS-SD, so
+phones and browsers find it as a web server, and over SSDP, so UPnP clients
+and other pilot instances do. It also searches the network for UPnP devices.
+*/
+package discovery
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+)
+
+// Service is a server to advertise.
+type Service struct {
+	// Name is the instance name shown to users, e.g. "Pilot on raspberrypi".
+	Name string
+	// Host is the name of the machine, advertised as Host.local.
+	Host string
+	Port int
+	// Text holds the key=value pairs of the DNS-SD TXT record.
+	Text map[string]string
+
+	// UUID identifies the server in SSDP, and should stay the same across
+	// restarts, see UUID().
+	UUID string
+	// Location is the path of the UPnP device description.
+	Location string
+	// Types are the SSDP search targets answered besides upnp:rootdevice and
+	// the UUID, e.g. device types.
+	Types []string
+
+	// Interface is the network interface to advertise on, or nil for the
+	// system's default one.
+	Interface *net.Interface
+}
+
+// UUID returns a name-based UUID for name, the same every time.
+func UUID(name string) string {
+	sum := md5.Sum([]byte(name))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// addrs returns the IPv4 addresses the service can be reached at: those of
+// its interface, or of every interface that is up but loopback.
+func (s *Service) addrs() []net.IP {
+	var ifaces []net.Interface
+	if s.Interface != nil {
+		ifaces = []net.Interface{*s.Interface}
+	} else {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return nil
+		}
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if s.Interface == nil && (iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				ips = append(ips, ipnet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
+
+// localIP returns the address of this machine that peer reaches it at, or
+// else its first address. A service on a given interface always uses that
+// interface's address.
+func (s *Service) localIP(peer net.IP) net.IP {
+	if peer != nil && s.Interface == nil {
+		// Connecting a UDP socket sends nothing, it only picks the route.
+		if conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: peer, Port: 9}); err == nil {
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).IP
+		}
+	}
+	if ips := s.addrs(); len(ips) > 0 {
+		return ips[0]
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
@@ -0,0 +1,23 @@
+package discovery
+
+import (
+	"net"
+	"syscall"
+)
+
+// multicastLoop has multicasts from conn reach this machine too, which Go
+// turns off, so other programs here like another pilot see the answers.
+func multicastLoop(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
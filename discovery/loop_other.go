@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package discovery
+
+import "net"
+
+// multicastLoop leaves multicasts from conn to other machines only.
+func multicastLoop(conn *net.UDPConn) error {
+	return nil
+}
@@ -0,0 +1,354 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MDNSGroup is where mDNS queries and answers are multicast.
+var MDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// ServiceType is the DNS-SD type pilot is advertised as.
+const ServiceType = "_http._tcp"
+
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+	// cacheFlush in the class of a record says it replaces what caches hold,
+	// unicastResponse in the class of a question asks for a unicast answer.
+	cacheFlush      = 0x8000
+	unicastResponse = 0x8000
+
+	// hostTTL and serviceTTL are the TTLs RFC 6762 recommends for records
+	// with and without a host name in them.
+	hostTTL    = 120
+	serviceTTL = 4500
+	// legacyTTL caps the TTLs in answers to plain DNS resolvers.
+	legacyTTL = 10
+)
+
+var errBadMessage = errors.New("malformed dns message")
+
+// name is a domain name as a list of labels, which may contain dots.
+type name []string
+
+func (n name) equal(other name) bool {
+	if len(n) != len(other) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n name) String() string {
+	return strings.Join(n, ".") + "."
+}
+
+type question struct {
+	name    name
+	qtype   uint16
+	unicast bool
+}
+
+type record struct {
+	name  name
+	rtype uint16
+	flush bool
+	ttl   uint32
+	data  []byte
+}
+
+// MDNS answers mDNS and DNS-SD queries for a Service. Build using
+// AdvertiseMDNS().
+type MDNS struct {
+	svc  *Service
+	conn *net.UDPConn
+	done chan struct{}
+
+	services name
+	instance name
+	host     name
+}
+
+// AdvertiseMDNS announces svc over mDNS and answers queries for it until
+// closed.
+func AdvertiseMDNS(svc *Service) (*MDNS, error) {
+	conn, err := net.ListenMulticastUDP("udp4", svc.Interface, MDNSGroup)
+	if err != nil {
+		return nil, err
+	}
+	if err := multicastLoop(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	services := append(strings.Split(ServiceType, "."), "local")
+	m := &MDNS{
+		svc:      svc,
+		conn:     conn,
+		done:     make(chan struct{}),
+		services: services,
+		instance: append(name{svc.Name}, services...),
+		host:     name{svc.Host, "local"},
+	}
+	go m.serve()
+	// RFC 6762 asks for at least two announcements, a second apart.
+	go func() {
+		for i := 0; i < 2; i++ {
+			m.send(m.announcement(false), MDNSGroup)
+			select {
+			case <-time.After(time.Second):
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m, nil
+}
+
+// Close says goodbye, so caches forget the service, and stops answering.
+func (m *MDNS) Close() error {
+	m.send(m.announcement(true), MDNSGroup)
+	err := m.conn.Close()
+	<-m.done
+	return err
+}
+
+func (m *MDNS) serve() {
+	defer close(m.done)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("error reading mdns query: %v", err)
+			}
+			return
+		}
+		id, questions, err := parseQuery(buf[:n])
+		if err != nil || len(questions) == 0 {
+			continue
+		}
+		answers, extra := m.answer(questions)
+		if len(answers) == 0 {
+			continue
+		}
+		if from.Port != MDNSGroup.Port {
+			// A plain DNS resolver asking, it wants a unicast DNS answer.
+			for _, rs := range [][]record{answers, extra} {
+				for i := range rs {
+					rs[i].flush = false
+					if rs[i].ttl > legacyTTL {
+						rs[i].ttl = legacyTTL
+					}
+				}
+			}
+			m.send(message(id, questions, answers, extra), from)
+			continue
+		}
+		to := MDNSGroup
+		if questions[0].unicast {
+			to = from
+		}
+		m.send(message(0, nil, answers, extra), to)
+	}
+}
+
+func (m *MDNS) send(msg []byte, to *net.UDPAddr) {
+	if _, err := m.conn.WriteToUDP(msg, to); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("error sending mdns answer: %v", err)
+	}
+}
+
+// announcement returns every record of the service in a message, with a TTL
+// of 0 if goodbye is set.
+func (m *MDNS) announcement(goodbye bool) []byte {
+	answers := append([]record{m.ptr(), m.srv(), m.txt()}, m.a()...)
+	if goodbye {
+		for i := range answers {
+			answers[i].ttl = 0
+		}
+	}
+	return message(0, nil, answers, nil)
+}
+
+// answer returns the records that answer questions, and those that the
+// asker will want next.
+func (m *MDNS) answer(questions []question) (answers, extra []record) {
+	metaQuery := name{"_services", "_dns-sd", "_udp", "local"}
+	for _, q := range questions {
+		is := func(t uint16) bool { return q.qtype == t || q.qtype == typeANY }
+		switch {
+		case q.name.equal(metaQuery) && is(typePTR):
+			answers = append(answers, record{q.name, typePTR, false, serviceTTL, appendName(nil, m.services)})
+		case q.name.equal(m.services) && is(typePTR):
+			answers = append(answers, m.ptr())
+			extra = append(append(extra, m.srv(), m.txt()), m.a()...)
+		case q.name.equal(m.instance):
+			if is(typeSRV) {
+				answers = append(answers, m.srv())
+				extra = append(extra, m.a()...)
+			}
+			if is(typeTXT) {
+				answers = append(answers, m.txt())
+			}
+		case q.name.equal(m.host) && is(typeA):
+			answers = append(answers, m.a()...)
+		}
+	}
+	return answers, extra
+}
+
+func (m *MDNS) ptr() record {
+	return record{m.services, typePTR, false, serviceTTL, appendName(nil, m.instance)}
+}
+
+func (m *MDNS) srv() record {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[4:], uint16(m.svc.Port))
+	return record{m.instance, typeSRV, true, hostTTL, appendName(data, m.host)}
+}
+
+func (m *MDNS) txt() record {
+	var keys []string
+	for k := range m.svc.Text {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var data []byte
+	for _, k := range keys {
+		s := k + "=" + m.svc.Text[k]
+		if len(s) > 255 {
+			continue
+		}
+		data = append(append(data, byte(len(s))), s...)
+	}
+	if len(data) == 0 {
+		// A TXT record can't be empty, it holds one empty string instead.
+		data = []byte{0}
+	}
+	return record{m.instance, typeTXT, true, serviceTTL, data}
+}
+
+func (m *MDNS) a() []record {
+	var records []record
+	for _, ip := range m.svc.addrs() {
+		records = append(records, record{m.host, typeA, true, hostTTL, []byte(ip.To4())})
+	}
+	return records
+}
+
+// parseQuery returns the ID and questions of a DNS query, or no questions if
+// msg is a response.
+func parseQuery(msg []byte) (uint16, []question, error) {
+	if len(msg) < 12 {
+		return 0, nil, errBadMessage
+	}
+	id := binary.BigEndian.Uint16(msg)
+	if msg[2]&0x80 != 0 {
+		return id, nil, nil
+	}
+	count := int(binary.BigEndian.Uint16(msg[4:]))
+	var questions []question
+	off := 12
+	for i := 0; i < count; i++ {
+		n, next, err := readName(msg, off)
+		if err != nil {
+			return 0, nil, err
+		}
+		if next+4 > len(msg) {
+			return 0, nil, errBadMessage
+		}
+		class := binary.BigEndian.Uint16(msg[next+2:])
+		questions = append(questions, question{n, binary.BigEndian.Uint16(msg[next:]), class&unicastResponse != 0})
+		off = next + 4
+	}
+	return id, questions, nil
+}
+
+// readName reads the name at off in msg, following compression pointers, and
+// returns it with the offset right after it.
+func readName(msg []byte, off int) (name, int, error) {
+	var n name
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errBadMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return n, end, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return nil, 0, errBadMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return nil, 0, errBadMessage
+			}
+			n = append(n, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+func appendName(b []byte, n name) []byte {
+	for _, label := range n {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+// message encodes an authoritative answer. Questions are only repeated for
+// plain DNS resolvers.
+func message(id uint16, questions []question, answers, extra []record) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, id)
+	binary.BigEndian.PutUint16(b[2:], 0x8400)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(extra)))
+	for _, q := range questions {
+		b = appendName(b, q.name)
+		b = append(b, byte(q.qtype>>8), byte(q.qtype), 0, classIN)
+	}
+	for _, r := range append(answers, extra...) {
+		b = appendName(b, r.name)
+		class := uint16(classIN)
+		if r.flush {
+			class |= cacheFlush
+		}
+		var header [10]byte
+		binary.BigEndian.PutUint16(header[0:], r.rtype)
+		binary.BigEndian.PutUint16(header[2:], class)
+		binary.BigEndian.PutUint32(header[4:], r.ttl)
+		binary.BigEndian.PutUint16(header[8:], uint16(len(r.data)))
+		b = append(append(b, header[:]...), r.data...)
+	}
+	return b
+}
@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopback returns a service advertised on the loopback interface, so tests
+// query it over local multicast only.
+func loopback(t *testing.T) *Service {
+	t.Helper()
+	lo, err := loopbackInterface()
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	return &Service{
+		Name:      "Pilot on test",
+		Host:      "test",
+		Port:      8080,
+		Text:      map[string]string{"path": "/", "pilot": "api/v1"},
+		UUID:      UUID("test:8080"),
+		Location:  "/upnp/description.xml",
+		Types:     []string{"urn:schemas-etherealmachine-com:device:Pilot:1"},
+		Interface: lo,
+	}
+}
+
+func loopbackInterface() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, net.UnknownNetworkError("loopback")
+}
+
+// query sends msg to group from 127.0.0.1, which has it go out on the
+// loopback interface, and returns the first answer.
+func query(t *testing.T, group *net.UDPAddr, msg []byte) []byte {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(msg, group); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 9000)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("no answer: %v", err)
+	}
+	return buf[:n]
+}
+
+func dnsQuery(n name, qtype uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, 42)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = appendName(b, n)
+	return append(b, byte(qtype>>8), byte(qtype), 0, classIN)
+}
+
+// parseAnswer returns the records of a DNS response.
+func parseAnswer(t *testing.T, msg []byte) []record {
+	t.Helper()
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		t.Fatalf("not a dns response: %x", msg)
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off = next + 4
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	var records []record
+	for i := 0; i < count; i++ {
+		n, next, err := readName(msg, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next+10 > len(msg) {
+			t.Fatal(errBadMessage)
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10 + length
+		if off > len(msg) {
+			t.Fatal(errBadMessage)
+		}
+		records = append(records, record{
+			name:  n,
+			rtype: binary.BigEndian.Uint16(msg[next:]),
+			flush: binary.BigEndian.Uint16(msg[next+2:])&cacheFlush != 0,
+			ttl:   binary.BigEndian.Uint32(msg[next+4:]),
+			data:  msg[next+10 : next+10+length],
+		})
+	}
+	return records
+}
+
+func TestMDNS(t *testing.T) {
+	svc := loopback(t)
+	m, err := AdvertiseMDNS(svc)
+	if err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+	defer m.Close()
+
+	records := parseAnswer(t, query(t, MDNSGroup, dnsQuery(name{"_http", "_tcp", "local"}, typePTR)))
+	byType := make(map[uint16]record)
+	for _, r := range records {
+		byType[r.rtype] = r
+		if r.ttl > legacyTTL || r.flush {
+			t.Errorf("%v: ttl %d, flush %v in a legacy unicast answer", r.name, r.ttl, r.flush)
+		}
+	}
+	instance := name{"Pilot on test", "_http", "_tcp", "local"}
+
+	ptr, ok := byType[typePTR]
+	if !ok {
+		t.Fatalf("no PTR record in %v", records)
+	}
+	if got := decodeName(t, ptr.data); !got.equal(instance) {
+		t.Errorf("PTR points to %v, want %v", got, instance)
+	}
+
+	srv, ok := byType[typeSRV]
+	if !ok {
+		t.Fatal("no SRV record")
+	}
+	if port := binary.BigEndian.Uint16(srv.data[4:]); port != 8080 {
+		t.Errorf("SRV port %d, want 8080", port)
+	}
+	if target := decodeName(t, srv.data[6:]); target.String() != "test.local." {
+		t.Errorf("SRV target %v, want test.local.", target)
+	}
+
+	txt, ok := byType[typeTXT]
+	if !ok {
+		t.Fatal("no TXT record")
+	}
+	var pairs []string
+	for data := txt.data; len(data) > 0; data = data[1+int(data[0]):] {
+		pairs = append(pairs, string(data[1:1+int(data[0])]))
+	}
+	if got := strings.Join(pairs, " "); got != "path=/ pilot=api/v1" {
+		t.Errorf("TXT %q, want %q", got, "path=/ pilot=api/v1")
+	}
+
+	a, ok := byType[typeA]
+	if !ok {
+		t.Fatal("no A record")
+	}
+	if ip := net.IP(a.data); !ip.IsLoopback() {
+		t.Errorf("A record %v, want a loopback address", ip)
+	}
+}
+
+// TestMDNSAnnounces listens on the group for the announcement and goodbye.
+func TestMDNSAnnounces(t *testing.T) {
+	svc := loopback(t)
+	conn, err := net.ListenMulticastUDP("udp4", svc.Interface, MDNSGroup)
+	if err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+	defer conn.Close()
+	m, err := AdvertiseMDNS(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txt := func(goodbye bool) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, 9000)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("no announcement: %v", err)
+			}
+			if buf[2]&0x80 == 0 {
+				continue
+			}
+			for _, r := range parseAnswer(t, buf[:n]) {
+				if r.rtype == typeTXT && r.flush && (r.ttl == 0) == goodbye {
+					return
+				}
+			}
+		}
+	}
+	txt(false)
+	m.Close()
+	txt(true)
+}
+
+func TestMDNSIgnoresOtherNames(t *testing.T) {
+	m, err := AdvertiseMDNS(loopback(t))
+	if err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+	defer m.Close()
+	answers, _ := m.answer([]question{{name: name{"_ipp", "_tcp", "local"}, qtype: typePTR}})
+	if len(answers) != 0 {
+		t.Errorf("answered a query for another service: %v", answers)
+	}
+	answers, _ = m.answer([]question{{name: name{"_services", "_dns-sd", "_udp", "local"}, qtype: typePTR}})
+	if len(answers) != 1 || !decodeName(t, answers[0].data).equal(name{"_http", "_tcp", "local"}) {
+		t.Errorf("meta query answered with %v", answers)
+	}
+}
+
+// decodeName reads a name that was written without compression.
+func decodeName(t *testing.T, data []byte) name {
+	t.Helper()
+	n, _, err := readName(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
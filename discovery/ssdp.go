@@ -0,0 +1,242 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSDPGroup is where SSDP searches and notifications are multicast.
+var SSDPGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpMaxAge is how long, in seconds, others may remember an advertisement.
+// It's renewed twice as often.
+const ssdpMaxAge = 1800
+
+var serverHeader = runtime.GOOS + "/1.0 UPnP/1.0 pilot/1.0"
+
+// SSDP answers SSDP searches for a Service and keeps notifying the network
+// of it. Build using AdvertiseSSDP().
+type SSDP struct {
+	svc  *Service
+	conn *net.UDPConn
+	stop chan struct{}
+	done chan struct{}
+}
+
+// AdvertiseSSDP announces svc over SSDP and answers searches for it until
+// closed.
+func AdvertiseSSDP(svc *Service) (*SSDP, error) {
+	conn, err := net.ListenMulticastUDP("udp4", svc.Interface, SSDPGroup)
+	if err != nil {
+		return nil, err
+	}
+	if err := multicastLoop(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	a := &SSDP{
+		svc:  svc,
+		conn: conn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	a.notify("ssdp:alive")
+	go a.serve()
+	go a.renew()
+	return a, nil
+}
+
+// Close says byebye and stops answering.
+func (a *SSDP) Close() error {
+	close(a.stop)
+	a.notify("ssdp:byebye")
+	err := a.conn.Close()
+	<-a.done
+	return err
+}
+
+// targets returns what the service can be searched for as.
+func (a *SSDP) targets() []string {
+	return append([]string{"upnp:rootdevice", "uuid:" + a.svc.UUID}, a.svc.Types...)
+}
+
+func (a *SSDP) usn(target string) string {
+	if strings.HasPrefix(target, "uuid:") {
+		return target
+	}
+	return "uuid:" + a.svc.UUID + "::" + target
+}
+
+func (a *SSDP) location(peer net.IP) string {
+	host := net.JoinHostPort(a.svc.localIP(peer).String(), strconv.Itoa(a.svc.Port))
+	return "http://" + host + a.svc.Location
+}
+
+func (a *SSDP) notify(nts string) {
+	location := a.location(nil)
+	for _, target := range a.targets() {
+		msg := "NOTIFY * HTTP/1.1\r\n" +
+			"HOST: " + SSDPGroup.String() + "\r\n" +
+			"NT: " + target + "\r\n" +
+			"NTS: " + nts + "\r\n" +
+			"USN: " + a.usn(target) + "\r\n"
+		if nts == "ssdp:alive" {
+			msg += fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge) +
+				"LOCATION: " + location + "\r\n" +
+				"SERVER: " + serverHeader + "\r\n"
+		}
+		a.send(msg+"\r\n", SSDPGroup)
+	}
+}
+
+func (a *SSDP) send(msg string, to *net.UDPAddr) {
+	if _, err := a.conn.WriteToUDP([]byte(msg), to); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("error sending ssdp message: %v", err)
+	}
+}
+
+func (a *SSDP) renew() {
+	ticker := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.notify("ssdp:alive")
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *SSDP) serve() {
+	defer close(a.done)
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("error reading ssdp search: %v", err)
+			}
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		st := req.Header.Get("ST")
+		var matches []string
+		for _, target := range a.targets() {
+			if st == "ssdp:all" || strings.EqualFold(st, target) {
+				matches = append(matches, target)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		// Answer at a random time within MX seconds, so devices don't all
+		// answer at once.
+		mx, _ := strconv.Atoi(req.Header.Get("MX"))
+		if mx < 1 {
+			mx = 1
+		}
+		if mx > 5 {
+			mx = 5
+		}
+		delay := time.Duration(rand.Int63n(int64(mx) * int64(time.Second)))
+		go a.answer(matches, from, delay)
+	}
+}
+
+func (a *SSDP) answer(targets []string, to *net.UDPAddr, delay time.Duration) {
+	select {
+	case <-time.After(delay):
+	case <-a.stop:
+		return
+	}
+	location := a.location(to.IP)
+	for _, target := range targets {
+		a.send("HTTP/1.1 200 OK\r\n"+
+			fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)+
+			"EXT:\r\n"+
+			"LOCATION: "+location+"\r\n"+
+			"SERVER: "+serverHeader+"\r\n"+
+			"ST: "+target+"\r\n"+
+			"USN: "+a.usn(target)+"\r\n\r\n", to)
+	}
+}
+
+// Result is a device that answered a Search.
+type Result struct {
+	// Target is what was searched for, USN what identifies the device and
+	// Location where its UPnP description is.
+	Target   string
+	USN      string
+	Location string
+	Server   string
+}
+
+// Search asks the devices on the network for target, e.g. ssdp:all or a
+// device type, and returns those that answer within wait.
+func Search(target string, wait time.Duration) ([]Result, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	mx := int(wait / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + SSDPGroup.String() + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		fmt.Sprintf("MX: %d\r\n", mx) +
+		"ST: " + target + "\r\n\r\n"
+	// UDP may drop the search, so it's sent twice as the spec suggests.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(msg), SSDPGroup); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, err
+	}
+	var results []Result
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return results, nil
+			}
+			return results, err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		usn := resp.Header.Get("USN")
+		if seen[usn] {
+			continue
+		}
+		seen[usn] = true
+		results = append(results, Result{
+			Target:   resp.Header.Get("ST"),
+			USN:      usn,
+			Location: resp.Header.Get("LOCATION"),
+			Server:   resp.Header.Get("SERVER"),
+		})
+	}
+}
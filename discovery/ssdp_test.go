@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// search sends an M-SEARCH for st from 127.0.0.1, which has it go out on the
+// loopback interface, and returns the answers that arrive within a second.
+func search(t *testing.T, st string) []*http.Response {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + SSDPGroup.String() + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + st + "\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(msg), SSDPGroup); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(1500 * time.Millisecond))
+	var answers []*http.Response
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return answers
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			t.Fatalf("bad answer %q: %v", buf[:n], err)
+		}
+		answers = append(answers, resp)
+	}
+}
+
+func TestSSDP(t *testing.T) {
+	svc := loopback(t)
+	a, err := AdvertiseSSDP(svc)
+	if err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+	defer a.Close()
+
+	uuid := "uuid:" + svc.UUID
+	var targets []string
+	for _, resp := range search(t, "ssdp:all") {
+		st := resp.Header.Get("ST")
+		targets = append(targets, st)
+		wantUSN := uuid + "::" + st
+		if st == uuid {
+			wantUSN = uuid
+		}
+		if usn := resp.Header.Get("USN"); usn != wantUSN {
+			t.Errorf("%s: USN %q, want %q", st, usn, wantUSN)
+		}
+		if location := resp.Header.Get("LOCATION"); location != "http://127.0.0.1:8080/upnp/description.xml" {
+			t.Errorf("%s: LOCATION %q", st, location)
+		}
+	}
+	sort.Strings(targets)
+	want := []string{"upnp:rootdevice", svc.Types[0], uuid}
+	sort.Strings(want)
+	if strings.Join(targets, " ") != strings.Join(want, " ") {
+		t.Errorf("ssdp:all found %v, want %v", targets, want)
+	}
+
+	answers := search(t, svc.Types[0])
+	if len(answers) != 1 || answers[0].Header.Get("ST") != svc.Types[0] {
+		t.Errorf("search for the device type got %d answers", len(answers))
+	}
+	if answers := search(t, "urn:schemas-upnp-org:device:MediaRenderer:1"); len(answers) != 0 {
+		t.Errorf("answered a search for another device type: %v", answers[0].Header)
+	}
+}
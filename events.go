@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/etherealmachine/pilot/player"
+)
+
+// pollInterval is how often the monitor asks the player for its status.
+const pollInterval = time.Second
+
+// maxBackoff is the longest the monitor waits between attempts to reach a
+// player that is down.
+const maxBackoff = time.Minute
+
+// errOffline is returned for anything that needs the player while it can't be
+// reached.
+var errOffline = errors.New("the TV is offline")
+
+// monitor polls the player of a device in the background, keeps the last
+// status it saw and pushes changes to every browser listening on /events.
+type monitor struct {
+	sync.RWMutex
+	device  string
+	status  *PlayerStatus
+	plID    int
+	file    string
+	clients map[chan []byte]bool
+
+	// stale is set when the queue changed while the player was offline, and
+	// resume if it was playing when it went offline.
+	stale  bool
+	resume bool
+	wake   chan struct{}
+}
+
+func newMonitor(device string) *monitor {
+	return &monitor{
+		device:  device,
+		plID:    -1,
+		clients: make(map[chan []byte]bool),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Status returns the last status seen, or nil if the player hasn't been reached yet.
+func (m *monitor) Status() *PlayerStatus {
+	m.RLock()
+	defer m.RUnlock()
+	return m.status
+}
+
+// Online reports whether the player answered the last status check.
+func (m *monitor) Online() bool {
+	status := m.Status()
+	return status != nil && status.Online
+}
+
+// Wake makes the monitor check on the player right away, e.g. after it moved.
+func (m *monitor) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// offline tells the browsers the player can't be reached, remembering if it
+// was playing so playback can resume when it's back.
+func (m *monitor) offline() {
+	m.Lock()
+	if m.status != nil && m.status.Online {
+		m.resume = m.status.State == "playing"
+	}
+	m.plID, m.file = -1, ""
+	m.Unlock()
+	m.update(&PlayerStatus{
+		Device:         m.device,
+		State:          "stopped",
+		AudioTracks:    []player.Track{},
+		SubtitleTracks: []player.Track{},
+		VideoTracks:    []player.Track{},
+	})
+}
+
+// markStale records that the queue must be synced once the player is back.
+func (m *monitor) markStale() {
+	m.Lock()
+	m.stale = true
+	m.Unlock()
+}
+
+// reconnected returns whether the queue was edited and whether the player was
+// playing while it was offline, and forgets both.
+func (m *monitor) reconnected() (stale, resume bool) {
+	m.Lock()
+	defer m.Unlock()
+	stale, resume = m.stale, m.resume
+	m.stale, m.resume = false, false
+	return stale, resume
+}
+
+// backoff returns how long to wait before trying a player again after it
+// failed the given number of times in a row.
+func backoff(failures int) time.Duration {
+	d := pollInterval
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// poll checks on the player of d every pollInterval while it's up, and with
+// an exponential backoff while it's down, so pilot keeps serving the library
+// without a TV. It returns once d is removed.
+func (s *server) poll(d *device) {
+	var failures int
+	for {
+		// The queue is checked against the player when pilot starts and
+		// whenever the player comes back, since VLC forgets its playlist when
+		// restarted.
+		restore := !d.Monitor.Online()
+		wait := pollInterval
+		if err := s.refresh(d); err != nil {
+			if failures == 0 {
+				log.Printf("error getting player status, %s is offline: %v", d.Name, err)
+				d.Monitor.offline()
+			}
+			failures++
+			wait = backoff(failures)
+		} else {
+			if failures > 0 {
+				log.Printf("%s is back online after %d failed checks", d.Name, failures)
+			}
+			failures = 0
+			if restore {
+				if err := s.restoreQueue(d); err != nil {
+					log.Printf("error restoring the play queue of %s: %v", d.Name, err)
+				}
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-d.Monitor.wake:
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// refresh fetches the status of the player of d and tells the browsers if it
+// changed.
+func (s *server) refresh(d *device) error {
+	status, err := d.player().Status()
+	if err != nil {
+		return err
+	}
+	ps := s.playerStatus(d, status)
+	m := d.Monitor
+	m.Lock()
+	plID := m.plID
+	started := status.Current != plID
+	ps.File = m.file
+	m.Unlock()
+	if started {
+		// The playlist is fetched without holding the lock, as it may take a
+		// while.
+		items, err := s.playlistItems(d)
+		if err != nil {
+			return err
+		}
+		ps.File = ""
+		for _, item := range items {
+			if item.ID == status.Current {
+				ps.File = item.File
+			}
+		}
+		m.Lock()
+		// Unless the player was switched or went offline meanwhile.
+		if m.plID == plID {
+			m.file, m.plID = ps.File, status.Current
+		}
+		m.Unlock()
+	}
+	if started && ps.File != "" {
+		s.followQueue(d, ps.File)
+	}
+	if ps.State == "stopped" {
+		ps.File, ps.Title = "", ""
+	}
+	if ps.File != "" && ps.State == "playing" {
+		if err := s.Progress.Update(ps.File, ps.Time, ps.Length); err != nil {
+			log.Printf("error saving watch progress: %v", err)
+		}
+	}
+	m.update(ps)
+	return nil
+}
+
+func (m *monitor) update(ps *PlayerStatus) {
+	m.Lock()
+	defer m.Unlock()
+	if !changed(m.status, ps) {
+		m.status = ps
+		return
+	}
+	m.status = ps
+	msg, err := json.Marshal(ps)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for c := range m.clients {
+		select {
+		case c <- msg:
+		default:
+			// The client is too slow to keep up, it'll get the next change.
+		}
+	}
+}
+
+func changed(old, new *PlayerStatus) bool {
+	if old == nil {
+		return true
+	}
+	return old.Online != new.Online ||
+		old.State != new.State ||
+		old.File != new.File ||
+		old.Title != new.Title ||
+		old.Time != new.Time ||
+		old.Length != new.Length ||
+		old.Volume != new.Volume ||
+		old.Rate != new.Rate ||
+		old.Chapter != new.Chapter ||
+		len(old.AudioTracks) != len(new.AudioTracks) ||
+		len(old.SubtitleTracks) != len(new.SubtitleTracks)
+}
+
+func (m *monitor) subscribe() chan []byte {
+	c := make(chan []byte, 8)
+	m.Lock()
+	m.clients[c] = true
+	m.Unlock()
+	return c
+}
+
+func (m *monitor) unsubscribe(c chan []byte) {
+	m.Lock()
+	delete(m.clients, c)
+	m.Unlock()
+}
+
+// EventsHandler streams the status of the player of a device to the browser
+// as Server-Sent Events.
+func (s *server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	d, err := s.requestDevice(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	c := d.Monitor.subscribe()
+	defer d.Monitor.unsubscribe(c)
+	if status := d.Monitor.Status(); status != nil {
+		if msg, err := json.Marshal(status); err == nil {
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", msg)
+		}
+	}
+	flusher.Flush()
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case msg := <-c:
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", msg)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestRefresh(t *testing.T) {
+	s := newTestServer(t, "TV")
+	d := s.devices[0]
+	pilot := "TV/Show/Season 1/Show - 101 - Pilot.mkv"
+	second := "TV/Show/Season 1/Show - 102 - Second.mkv"
+	if err := d.Queue.Replace(pilot, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pushQueue(d, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.player().Seek(120, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	ps := d.Monitor.Status()
+	if !ps.Online || ps.State != "playing" || ps.File != pilot || ps.Time < 120 {
+		t.Errorf("status %+v, want the pilot playing from 120s", ps)
+	}
+	if p := s.Progress.Get(pilot); p.Position < 120 || p.Length != ps.Length {
+		t.Errorf("progress %+v, want 120s of %ds", p, ps.Length)
+	}
+
+	// The queue follows the player on to the next file.
+	if err := d.player().Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	if ps := d.Monitor.Status(); ps.File != second {
+		t.Errorf("playing %q after next, want %q", ps.File, second)
+	}
+	if playing := d.Queue.Playing(); playing != second {
+		t.Errorf("queue at %q after next, want %q", playing, second)
+	}
+
+	// Near its end, a file counts as watched.
+	status, err := d.player().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.player().Seek(status.Length-30, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Progress.Watched(second) {
+		t.Errorf("%s not watched 30s from its end", second)
+	}
+
+	if err := d.player().Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	if ps := d.Monitor.Status(); ps.State != "stopped" || ps.File != "" {
+		t.Errorf("status %+v after stopping", ps)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etherealmachine/pilot/player"
+)
+
+// syncInterval is how often a group compares the positions of its devices.
+const syncInterval = 2 * time.Second
+
+const (
+	// maxDrift is how far a device may drift from the leader of its group
+	// before it's nudged back by playing it a little slower or faster.
+	maxDrift = 100 * time.Millisecond
+	// maxNudge is the most the playback speed is changed by for that, which
+	// is hard to notice.
+	maxNudge = 0.05
+	// seekDrift is how far a device may drift before it seeks instead.
+	seekDrift = 2 * time.Second
+)
+
+// group plays a file on several devices at once, keeping them in sync with
+// the first one, the leader. A device is in one group at most. Build using
+// PlayInSync().
+type group struct {
+	sync.Mutex
+	ID      int
+	File    string
+	members []*member
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+type member struct {
+	device *device
+	// drift is how far ahead of the leader the device was at the last check,
+	// and rate the speed it was set to.
+	drift time.Duration
+	rate  float64
+}
+
+// GroupState is a group as listed by the API.
+type GroupState struct {
+	ID      int           `json:"id"`
+	File    string        `json:"file"`
+	Devices []GroupMember `json:"devices"`
+}
+
+// GroupMember is a device of a group. Drift is how far ahead of the leader it
+// is, in seconds.
+type GroupMember struct {
+	Name  string  `json:"name"`
+	Drift float64 `json:"drift"`
+	Rate  float64 `json:"rate"`
+}
+
+func (g *group) state() GroupState {
+	g.Lock()
+	defer g.Unlock()
+	state := GroupState{ID: g.ID, File: g.File, Devices: []GroupMember{}}
+	for _, m := range g.members {
+		state.Devices = append(state.Devices, GroupMember{
+			Name:  m.device.Name,
+			Drift: m.drift.Seconds(),
+			Rate:  m.rate,
+		})
+	}
+	return state
+}
+
+func (g *group) has(d *device) bool {
+	g.Lock()
+	defer g.Unlock()
+	for _, m := range g.members {
+		if m.device == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops keeping the devices in sync, leaving them playing, and waits
+// until they're back to normal speed.
+func (g *group) Stop() {
+	g.once.Do(func() {
+		close(g.stop)
+	})
+	<-g.done
+}
+
+// together runs f for every device at once, so they act as close together as
+// possible, and returns the first error.
+func together(devices []*device, f func(d *device) error) error {
+	errs := make(chan error, len(devices))
+	for _, d := range devices {
+		go func(d *device) {
+			if err := f(d); err != nil {
+				errs <- fmt.Errorf("%s: %v", d.Name, err)
+				return
+			}
+			errs <- nil
+		}(d)
+	}
+	var first error
+	for range devices {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// PlayInSync plays filename on every device, starting start seconds in, and
+// keeps them in sync until one of them plays something else. The first
+// device leads; devices listed twice play once. Groups the devices were in
+// before end.
+func (s *server) PlayInSync(devices []*device, filename string, start int) (*group, error) {
+	var unique []*device
+	seen := make(map[*device]bool)
+	for _, d := range devices {
+		if !seen[d] {
+			seen[d] = true
+			unique = append(unique, d)
+		}
+	}
+	devices = unique
+	if len(devices) < 2 {
+		return nil, badRequest("playing in sync needs at least two devices")
+	}
+	var names []string
+	for _, d := range devices {
+		if !d.Monitor.Online() {
+			return nil, fmt.Errorf("%s: %w", d.Name, errOffline)
+		}
+		names = append(names, d.Name)
+	}
+	for _, g := range s.groupList() {
+		for _, d := range devices {
+			if g.has(d) {
+				g.Stop()
+				break
+			}
+		}
+	}
+	log.Printf("playing %s in sync on %s", filepath.Join(s.Library.Root, filename), strings.Join(names, ", "))
+	for _, d := range devices {
+		if err := d.Queue.Replace(filename); err != nil {
+			return nil, err
+		}
+		if err := s.pushQueue(d, true, 0); err != nil {
+			return nil, fmt.Errorf("%s: %v", d.Name, err)
+		}
+	}
+	// Hold every device at the start until all of them opened the file, then
+	// start them together.
+	err := together(devices, func(d *device) error {
+		if err := s.waitStarted(d); err != nil {
+			return err
+		}
+		if err := d.player().Pause(); err != nil {
+			return err
+		}
+		if err := d.player().SetRate(1); err != nil {
+			return err
+		}
+		return d.player().Seek(start, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := together(devices, func(d *device) error { return d.player().Play() }); err != nil {
+		return nil, err
+	}
+	g := &group{
+		File: filename,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, d := range devices {
+		g.members = append(g.members, &member{device: d, rate: 1})
+	}
+	s.Lock()
+	s.groupID++
+	g.ID = s.groupID
+	s.groups = append(s.groups, g)
+	s.Unlock()
+	go s.syncGroup(g)
+	return g, nil
+}
+
+// groupList returns the groups playing.
+func (s *server) groupList() []*group {
+	s.RLock()
+	defer s.RUnlock()
+	return s.groups
+}
+
+// syncGroup checks on g every syncInterval until it is stopped or no longer
+// plays on more than one device.
+func (s *server) syncGroup(g *group) {
+	defer close(g.done)
+	defer func() {
+		s.Lock()
+		for i, other := range s.groups {
+			if other == g {
+				s.groups = append(s.groups[:i:i], s.groups[i+1:]...)
+				break
+			}
+		}
+		s.Unlock()
+		g.Lock()
+		for _, m := range g.members {
+			if m.rate != 1 {
+				if err := m.device.player().SetRate(1); err != nil {
+					log.Printf("error resetting the speed of %s: %v", m.device.Name, err)
+				}
+			}
+		}
+		g.Unlock()
+	}()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.stop:
+			return
+		}
+		if !s.sync(g) {
+			log.Printf("stopped playing %s in sync", g.File)
+			return
+		}
+	}
+}
+
+// sync brings the devices of g in line with the leader, dropping those that
+// stopped playing the file, and reports whether there's still a group left.
+func (s *server) sync(g *group) bool {
+	g.Lock()
+	defer g.Unlock()
+	statuses := make([]*player.Status, len(g.members))
+	at := make([]time.Time, len(g.members))
+	together(g.devices(), func(d *device) error {
+		i := g.index(d)
+		before := time.Now()
+		status, err := d.player().Status()
+		if err != nil {
+			return err
+		}
+		// The status is closest to the middle of the round trip.
+		statuses[i], at[i] = status, before.Add(time.Since(before)/2)
+		return nil
+	})
+	var members []*member
+	var playing []*player.Status
+	var playingAt []time.Time
+	for i, m := range g.members {
+		status := statuses[i]
+		ps := m.device.Monitor.Status()
+		if status == nil || status.State == "stopped" || ps == nil || ps.File != g.File {
+			if i == 0 {
+				log.Printf("%s, leading the group, stopped playing %s", m.device.Name, g.File)
+				return false
+			}
+			log.Printf("%s stopped playing %s in sync", m.device.Name, g.File)
+			if m.rate != 1 {
+				if err := m.device.player().SetRate(1); err != nil {
+					log.Printf("error resetting the speed of %s: %v", m.device.Name, err)
+				}
+			}
+			continue
+		}
+		members = append(members, m)
+		playing, playingAt = append(playing, status), append(playingAt, at[i])
+	}
+	g.members = members
+	if len(members) < 2 {
+		return false
+	}
+	leader := playing[0]
+	for i, m := range members[1:] {
+		status, at := playing[i+1], playingAt[i+1]
+		var err error
+		switch {
+		// Followers pause and resume with the leader.
+		case leader.State == "paused" && status.State == "playing":
+			err = m.device.player().Pause()
+		case leader.State == "playing" && status.State == "paused":
+			err = m.device.player().Play()
+		case leader.State == "playing":
+			err = s.correct(m, status, at, leader, playingAt[0])
+		}
+		if err != nil {
+			log.Printf("error syncing %s: %v", m.device.Name, err)
+		}
+	}
+	return true
+}
+
+// devices returns the devices of g, which must be locked.
+func (g *group) devices() []*device {
+	var devices []*device
+	for _, m := range g.members {
+		devices = append(devices, m.device)
+	}
+	return devices
+}
+
+func (g *group) index(d *device) int {
+	for i, m := range g.members {
+		if m.device == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// elapsedSince returns how much further into the file a player with status,
+// taken at from, got by to.
+func elapsedSince(status *player.Status, from, to time.Time) time.Duration {
+	if status.State != "playing" {
+		return 0
+	}
+	return time.Duration(float64(to.Sub(from)) * status.Rate)
+}
+
+// correct nudges the device of m towards the leader. The status of m was
+// taken at at, that of the leader at leaderAt.
+func (s *server) correct(m *member, status *player.Status, at time.Time, leader *player.Status, leaderAt time.Time) error {
+	m.drift = status.Elapsed() - (leader.Elapsed() + elapsedSince(leader, leaderAt, at))
+	drift := m.drift
+	if drift < 0 {
+		drift = -drift
+	}
+	rate := 1.0
+	switch {
+	case drift > seekDrift:
+		log.Printf("%s is %v off, seeking", m.device.Name, m.drift.Round(time.Millisecond))
+		if m.rate != 1 {
+			if err := m.device.player().SetRate(1); err != nil {
+				return err
+			}
+			m.rate = 1
+		}
+		to := leader.Elapsed() + elapsedSince(leader, leaderAt, time.Now())
+		return m.device.player().Seek(int(to.Round(time.Second).Seconds()), false)
+	case drift > maxDrift:
+		// Make up for the drift over the next interval, as far as the speed
+		// can change unnoticed.
+		rate = 1 - m.drift.Seconds()/syncInterval.Seconds()
+		if rate < 1-maxNudge {
+			rate = 1 - maxNudge
+		}
+		if rate > 1+maxNudge {
+			rate = 1 + maxNudge
+		}
+	}
+	if rate == m.rate {
+		return nil
+	}
+	if err := m.device.player().SetRate(rate); err != nil {
+		return err
+	}
+	m.rate = rate
+	return nil
+}
+
+func (s *server) groupStates() []GroupState {
+	states := []GroupState{}
+	for _, g := range s.groupList() {
+		states = append(states, g.state())
+	}
+	return states
+}
+
+func (s *server) apiGroups(r *http.Request, path []string) (interface{}, error) {
+	switch {
+	case len(path) == 0 && r.Method == http.MethodGet:
+		return s.groupStates(), nil
+	case len(path) == 0 && r.Method == http.MethodPost:
+		req, err := parseAPIRequest(r)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		var devices []*device
+		for _, name := range req.Devices {
+			d := s.findDevice(name)
+			if d == nil {
+				return nil, badRequest("no device called %q", name)
+			}
+			devices = append(devices, d)
+		}
+		if len(req.Devices) == 0 {
+			devices = s.onlineDevices()
+		}
+		g, err := s.PlayInSync(devices, req.File, req.Time)
+		if err != nil {
+			return nil, err
+		}
+		return g.state(), nil
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		id, err := strconv.Atoi(path[0])
+		if err != nil {
+			return nil, errNotFound
+		}
+		for _, g := range s.groupList() {
+			if g.ID == id {
+				g.Stop()
+				return s.groupStates(), nil
+			}
+		}
+	}
+	return nil, errNotFound
+}
+
+// onlineDevices returns the devices that can be played on.
+func (s *server) onlineDevices() []*device {
+	var devices []*device
+	for _, d := range s.deviceList() {
+		if d.Monitor.Online() {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
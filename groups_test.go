@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etherealmachine/pilot/player"
+)
+
+func TestElapsedSince(t *testing.T) {
+	from := time.Now()
+	to := from.Add(2 * time.Second)
+	for _, test := range []struct {
+		state string
+		rate  float64
+		want  time.Duration
+	}{
+		{"playing", 1, 2 * time.Second},
+		{"playing", 0.95, 1900 * time.Millisecond},
+		{"paused", 1, 0},
+		{"stopped", 1, 0},
+	} {
+		if got := elapsedSince(&player.Status{State: test.state, Rate: test.rate}, from, to); got != test.want {
+			t.Errorf("%s at %v: elapsed %v, want %v", test.state, test.rate, got, test.want)
+		}
+	}
+}
+
+// TestSync plays in sync on four devices: the leader, one in step with it, one
+// a little behind and one far ahead.
+func TestSync(t *testing.T) {
+	s := newTestServer(t, "Leader", "Even", "Behind", "Ahead")
+	leader, even, behind, ahead := s.devices[0], s.devices[1], s.devices[2], s.devices[3]
+	file := "Movies/Heat (1995).mkv"
+	g, err := s.PlayInSync(s.devices, file, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+	for _, d := range s.devices {
+		if err := s.refresh(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.members[0].device != leader {
+		t.Errorf("%s leads, want %s", g.members[0].device.Name, leader.Name)
+	}
+	if err := behind.player().Seek(-1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ahead.player().Seek(10, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.sync(g) {
+		t.Fatal("the group broke up")
+	}
+	status := func(d *device) *player.Status {
+		t.Helper()
+		status, err := d.player().Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	drift := func(d *device) time.Duration {
+		t.Helper()
+		return status(d).Elapsed() - status(leader).Elapsed()
+	}
+	if rate := status(even).Rate; rate != 1 {
+		t.Errorf("%s, in step, plays at %v", even.Name, rate)
+	}
+	if d := drift(even); d < -maxDrift || d > maxDrift {
+		t.Errorf("%s, in step, moved %v off", even.Name, d)
+	}
+	// A device within seekDrift is caught up with by playing it faster,
+	// rather than seeking.
+	if rate := status(behind).Rate; rate != 1+maxNudge {
+		t.Errorf("%s, a second behind, plays at %v, want %v", behind.Name, rate, 1+maxNudge)
+	}
+	if d := drift(behind); d > -900*time.Millisecond {
+		t.Errorf("%s, a second behind, seeked to %v off", behind.Name, d)
+	}
+	// One further off seeks to the leader.
+	if rate := status(ahead).Rate; rate != 1 {
+		t.Errorf("%s, seeking, plays at %v", ahead.Name, rate)
+	}
+	if d := drift(ahead); d < -time.Second || d > time.Second {
+		t.Errorf("%s is %v off after seeking", ahead.Name, d)
+	}
+
+	// A device playing something else leaves the group, and the group ends
+	// with the leader.
+	if err := s.PlayOnTV(even, "Movies/Alien (1979).mkv", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(even); err != nil {
+		t.Fatal(err)
+	}
+	if !s.sync(g) || g.has(even) {
+		t.Errorf("%s still in the group playing something else", even.Name)
+	}
+	if err := leader.player().Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.sync(g) {
+		t.Error("the group outlasted its leader")
+	}
+}
+
+func TestPlayInSyncTwice(t *testing.T) {
+	s := newTestServer(t, "TV", "Projector")
+	tv := s.devices[0]
+	if _, err := s.PlayInSync([]*device{tv, tv}, "Movies/Heat (1995).mkv", 0); err == nil {
+		t.Error("played in sync on one device listed twice")
+	}
+	g, err := s.PlayInSync([]*device{tv, s.devices[1], tv}, "Movies/Heat (1995).mkv", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+	if state := g.state(); len(state.Devices) != 2 {
+		t.Errorf("group of %+v", state.Devices)
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Package library keeps a persistent index of the media files pilot serves.
+*/
+package library
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Video is the set of file extensions the library considers playable.
+var Video = map[string]bool{
+	".mp4":  true,
+	".avi":  true,
+	".mpg":  true,
+	".mov":  true,
+	".wmv":  true,
+	".mkv":  true,
+	".m4v":  true,
+	".webm": true,
+	".flv":  true,
+	".3gp":  true,
+}
+
+// File is a single media file in the index. Path is relative to the index root.
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Inode   uint64    `json:"inode"`
+}
+
+// Dir records what a directory held the last time it was read, so unchanged
+// directories can be skipped on the next scan.
+type Dir struct {
+	ModTime time.Time
+	Dirs    []string
+	Files   []string
+}
+
+// Index is an on-disk index of the media files under Root, limited to the
+// Folders subtrees. Build using Open().
+type Index struct {
+	sync.RWMutex
+	Root    string
+	Folders []string
+	Files   map[string]*File
+	Dirs    map[string]*Dir
+
+	// Modified is the last time a scan found the library had changed.
+	Modified time.Time
+
+	filename string
+	scan     sync.Mutex
+	// save serializes saves, which share the temporary file.
+	save sync.Mutex
+}
+
+// snapshot is the part of an Index that is persisted to disk.
+type snapshot struct {
+	Root     string
+	Files    map[string]*File
+	Dirs     map[string]*Dir
+	Modified time.Time
+}
+
+// Open loads the index saved in filename, or returns an empty index if it does
+// not exist yet. Call Scan to bring it up to date with the disk.
+func Open(filename string, root string, folders []string) (*Index, error) {
+	idx := &Index{
+		Root:     root,
+		Folders:  folders,
+		Files:    make(map[string]*File),
+		Dirs:     make(map[string]*Dir),
+		filename: filename,
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var saved snapshot
+	if err := gob.NewDecoder(f).Decode(&saved); err != nil {
+		return nil, err
+	}
+	if saved.Root == root {
+		idx.Files = saved.Files
+		idx.Dirs = saved.Dirs
+		idx.Modified = saved.Modified
+	}
+	return idx, nil
+}
+
+// Save writes the index to disk, replacing the previous copy atomically.
+func (idx *Index) Save() error {
+	idx.save.Lock()
+	defer idx.save.Unlock()
+	idx.RLock()
+	defer idx.RUnlock()
+	tmp := idx.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(&snapshot{
+		Root:     idx.Root,
+		Files:    idx.Files,
+		Dirs:     idx.Dirs,
+		Modified: idx.Modified,
+	}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.filename)
+}
+
+// Scan brings the index up to date with the disk. Only directories whose
+// modification time changed since the last scan are read again.
+func (idx *Index) Scan() error {
+	return idx.Refresh()
+}
+
+// Refresh is like Scan, but also reads the given directories again even if
+// their modification time is unchanged, e.g. because a file inside them was
+// rewritten. Directories are relative to the index root.
+func (idx *Index) Refresh(dirs ...string) error {
+	idx.scan.Lock()
+	defer idx.scan.Unlock()
+	idx.RLock()
+	s := &scanner{
+		root:     idx.Root,
+		force:    make(map[string]bool),
+		oldDirs:  idx.Dirs,
+		oldFiles: idx.Files,
+		dirs:     make(map[string]*Dir),
+		files:    make(map[string]*File),
+	}
+	folders := idx.Folders
+	idx.RUnlock()
+	for _, dir := range dirs {
+		s.force[filepath.Clean(dir)] = true
+	}
+	for _, folder := range folders {
+		if err := s.walk(filepath.Clean(folder)); err != nil {
+			return err
+		}
+	}
+	idx.Lock()
+	idx.Dirs = s.dirs
+	idx.Files = s.files
+	if s.changed || len(s.files) != len(s.oldFiles) {
+		idx.Modified = time.Now()
+	}
+	idx.Unlock()
+	return nil
+}
+
+// SetFolders changes the folders the index covers. Call Scan to pick up the
+// change.
+func (idx *Index) SetFolders(folders []string) {
+	idx.Lock()
+	idx.Folders = folders
+	idx.Unlock()
+}
+
+// LastModified returns the last time a scan found the library had changed.
+func (idx *Index) LastModified() time.Time {
+	idx.RLock()
+	defer idx.RUnlock()
+	return idx.Modified
+}
+
+// Directories returns every directory in the index, relative to the index root.
+func (idx *Index) Directories() []string {
+	idx.RLock()
+	defer idx.RUnlock()
+	dirs := make([]string, 0, len(idx.Dirs))
+	for dir := range idx.Dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// List returns every file in the index, sorted by path.
+func (idx *Index) List() []File {
+	idx.RLock()
+	defer idx.RUnlock()
+	files := make([]File, 0, len(idx.Files))
+	for _, f := range idx.Files {
+		files = append(files, *f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// Get returns the file at path, relative to the root, or nil if it isn't indexed.
+func (idx *Index) Get(path string) *File {
+	idx.RLock()
+	defer idx.RUnlock()
+	if f, ok := idx.Files[path]; ok {
+		c := *f
+		return &c
+	}
+	return nil
+}
+
+// Len returns the number of files in the index.
+func (idx *Index) Len() int {
+	idx.RLock()
+	defer idx.RUnlock()
+	return len(idx.Files)
+}
+
+type scanner struct {
+	root     string
+	force    map[string]bool
+	changed  bool
+	oldDirs  map[string]*Dir
+	oldFiles map[string]*File
+	dirs     map[string]*Dir
+	files    map[string]*File
+}
+
+func (s *scanner) walk(rel string) error {
+	info, err := os.Stat(filepath.Join(s.root, rel))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	dir := s.oldDirs[rel]
+	if dir == nil || !dir.ModTime.Equal(info.ModTime()) || s.force[rel] {
+		dir, err = s.read(rel, info.ModTime())
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, name := range dir.Files {
+			path := filepath.Join(rel, name)
+			if f := s.oldFiles[path]; f != nil {
+				s.files[path] = f
+			}
+		}
+	}
+	s.dirs[rel] = dir
+	for _, name := range dir.Dirs {
+		if err := s.walk(filepath.Join(rel, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scanner) read(rel string, modTime time.Time) (*Dir, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, rel))
+	if err != nil {
+		return nil, err
+	}
+	dir := &Dir{ModTime: modTime}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(rel, name)
+		info, err := os.Stat(filepath.Join(s.root, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			dir.Dirs = append(dir.Dirs, name)
+			continue
+		}
+		if !Video[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		f := &File{
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Inode:   inode(info),
+		}
+		if old := s.oldFiles[path]; old == nil || old.Size != f.Size || !old.ModTime.Equal(f.ModTime) || old.Inode != f.Inode {
+			s.changed = true
+		}
+		s.files[path] = f
+		dir.Files = append(dir.Files, name)
+	}
+	return dir, nil
+}
+
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
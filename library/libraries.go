@@ -0,0 +1,192 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of library, telling how their files are named and shown.
+const (
+	MoviesKind      = "movies"
+	ShowsKind       = "shows"
+	HomeVideosKind  = "home"
+	MusicVideosKind = "musicvideos"
+	ConcertsKind    = "concerts"
+)
+
+// Kinds lists every kind of library with a display name.
+var Kinds = map[string]string{
+	MoviesKind:      "Movies",
+	ShowsKind:       "TV shows",
+	HomeVideosKind:  "Home videos",
+	MusicVideosKind: "Music videos",
+	ConcertsKind:    "Concerts",
+}
+
+// Library is a named collection of folders, relative to the root, holding
+// one kind of video.
+type Library struct {
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind"`
+	Paths []string `json:"paths"`
+}
+
+// ParseLibraries parses a comma-separated list of libraries, each written
+// name:kind:path[:path...]. A bare folder name is a library of that name and
+// path, whose kind is guessed from the name.
+func ParseLibraries(spec string) ([]*Library, error) {
+	var libraries []*Library
+	names := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		l := &Library{Name: parts[0]}
+		switch len(parts) {
+		case 1:
+			l.Kind, l.Paths = GuessKind(entry), []string{entry}
+		case 2:
+			return nil, fmt.Errorf("library %q has no paths, expected name:kind:path", entry)
+		default:
+			l.Kind, l.Paths = parts[1], parts[2:]
+		}
+		if err := l.Validate(); err != nil {
+			return nil, err
+		}
+		if names[strings.ToLower(l.Name)] {
+			return nil, fmt.Errorf("library %q is defined twice", l.Name)
+		}
+		names[strings.ToLower(l.Name)] = true
+		libraries = append(libraries, l)
+	}
+	if len(libraries) == 0 {
+		return nil, fmt.Errorf("no libraries in %q", spec)
+	}
+	return libraries, nil
+}
+
+// GuessKind returns the kind of library a folder called name probably is.
+func GuessKind(name string) string {
+	switch strings.ToLower(name) {
+	case "tv", "shows", "tv shows", "series":
+		return ShowsKind
+	case "movies", "films":
+		return MoviesKind
+	case "music videos", "musicvideos":
+		return MusicVideosKind
+	case "concerts":
+		return ConcertsKind
+	}
+	return HomeVideosKind
+}
+
+// Validate checks the library has a name, a known kind and relative paths.
+func (l *Library) Validate() error {
+	if strings.TrimSpace(l.Name) == "" {
+		return fmt.Errorf("library with paths %v has no name", l.Paths)
+	}
+	if _, ok := Kinds[l.Kind]; !ok {
+		return fmt.Errorf("library %q has unknown kind %q", l.Name, l.Kind)
+	}
+	if len(l.Paths) == 0 {
+		return fmt.Errorf("library %q has no paths", l.Name)
+	}
+	for i, path := range l.Paths {
+		path = filepath.Clean(path)
+		if path == "." || filepath.IsAbs(path) || outside(path) {
+			return fmt.Errorf("library %q path %q must be a folder under the root", l.Name, l.Paths[i])
+		}
+		l.Paths[i] = path
+	}
+	return nil
+}
+
+// Rel returns path relative to the library folder holding it, and whether
+// the library holds it at all.
+func (l *Library) Rel(path string) (string, bool) {
+	for _, folder := range l.Paths {
+		if rel, err := filepath.Rel(folder, path); err == nil && !outside(rel) {
+			return rel, true
+		}
+	}
+	return "", false
+}
+
+// outside returns whether the relative path rel leads out of its folder. Names
+// like "..foo" are still inside.
+func outside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Files returns the files that are in the library.
+func (l *Library) Files(files []File) []File {
+	var in []File
+	for _, f := range files {
+		if _, ok := l.Rel(f.Path); ok {
+			in = append(in, f)
+		}
+	}
+	return in
+}
+
+// Folders returns every path of every library, for Open.
+func Folders(libraries []*Library) []string {
+	var folders []string
+	for _, l := range libraries {
+		folders = append(folders, l.Paths...)
+	}
+	return folders
+}
+
+// Group is a set of files shown together in libraries of home and music
+// videos, named after their folder or artist.
+type Group struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// Groups sorts the files of a home or music videos library into groups: music
+// videos named "Artist - Title" by artist, everything else by folder. Home
+// videos are listed newest folder first, assuming folders are named by date.
+func (l *Library) Groups(files []File) []*Group {
+	groups := make(map[string]*Group)
+	var sorted []*Group
+	for _, f := range files {
+		rel, ok := l.Rel(f.Path)
+		if !ok {
+			continue
+		}
+		name := filepath.Dir(rel)
+		if name == "." {
+			name = ""
+		}
+		if l.Kind == MusicVideosKind {
+			base := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+			if i := strings.Index(base, " - "); i > 0 {
+				name = cleanName(base[:i])
+			}
+		}
+		group := groups[strings.ToLower(name)]
+		if group == nil {
+			group = &Group{Name: name}
+			groups[strings.ToLower(name)] = group
+			sorted = append(sorted, group)
+		}
+		group.Files = append(group.Files, f.Path)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := strings.ToLower(sorted[i].Name), strings.ToLower(sorted[j].Name)
+		if l.Kind == HomeVideosKind {
+			return a > b
+		}
+		return a < b
+	})
+	for _, group := range sorted {
+		sort.Strings(group.Files)
+	}
+	return sorted
+}
@@ -0,0 +1,35 @@
+package library
+
+import "testing"
+
+func TestRel(t *testing.T) {
+	l := &Library{Name: "Movies", Kind: MoviesKind, Paths: []string{"Movies"}}
+	for path, want := range map[string]string{
+		"Movies/Alien.mkv":     "Alien.mkv",
+		"Movies/..Alien.mkv":   "..Alien.mkv",
+		"Movies/../Heat.mkv":   "",
+		"Movies":               ".",
+		"TV/Show/S01E01.mkv":   "",
+		"Movies2/Predator.mkv": "",
+	} {
+		rel, ok := l.Rel(path)
+		if rel != want || ok != (want != "") {
+			t.Errorf("Rel(%q) = %q, %v, want %q", path, rel, ok, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, path := range []string{"..Movies", "Movies/../..Movies"} {
+		l := &Library{Name: "Movies", Kind: MoviesKind, Paths: []string{path}}
+		if err := l.Validate(); err != nil {
+			t.Errorf("Validate(%q): %v", path, err)
+		}
+	}
+	for _, path := range []string{"..", "../Movies", "Movies/../..", "Movies/..", "/media/Movies"} {
+		l := &Library{Name: "Movies", Kind: MoviesKind, Paths: []string{path}}
+		if err := l.Validate(); err == nil {
+			t.Errorf("Validate(%q) passed", path)
+		}
+	}
+}
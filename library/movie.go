@@ -0,0 +1,244 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MovieFile is a single movie file, as parsed from its name and location.
+type MovieFile struct {
+	File       string `json:"file"`
+	Title      string `json:"title"`
+	Year       int    `json:"year,omitempty"`
+	Edition    string `json:"edition,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+}
+
+// Movie groups the files that hold the same movie, e.g. different editions
+// or qualities.
+type Movie struct {
+	Title string       `json:"title"`
+	Year  int          `json:"year,omitempty"`
+	Files []*MovieFile `json:"files"`
+}
+
+// movieTag is a tag of the release, matched as a whole token. Tags that are
+// also common words, like "avc" or "extended", only count in the release-tag
+// tail: after the year, or from the first tag that isn't a word on.
+type movieTag struct {
+	re    *regexp.Regexp
+	value string
+	word  bool
+}
+
+func tags(values map[string]string) []movieTag {
+	var t []movieTag
+	for pattern, value := range values {
+		t = append(t, movieTag{regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(` + pattern + `)(?:[^a-z0-9]|$)`), value, false})
+	}
+	return t
+}
+
+func words(values map[string]string) []movieTag {
+	t := tags(values)
+	for i := range t {
+		t[i].word = true
+	}
+	return t
+}
+
+// index returns where t first occurs in name from start on, or -1.
+func (t movieTag) index(name string, start int) int {
+	for _, loc := range t.re.FindAllStringSubmatchIndex(name, -1) {
+		if loc[2] >= start {
+			return loc[2]
+		}
+	}
+	return -1
+}
+
+var (
+	yearRe      = regexp.MustCompile(`(?:19|20)\d{2}`)
+	bracketedRe = regexp.MustCompile(`\[[^\]]*\]`)
+
+	resolutions = append(tags(map[string]string{
+		`480p`:  "480p",
+		`576p`:  "576p",
+		`720p`:  "720p",
+		`1080p`: "1080p",
+		`1080i`: "1080i",
+		`2160p`: "2160p",
+	}), words(map[string]string{
+		`4k|uhd`: "2160p",
+	})...)
+	sources = append(tags(map[string]string{
+		`blu-?ray|bdrip|brrip`: "BluRay",
+		`web-?dl`:              "WEB-DL",
+		`webrip`:               "WEBRip",
+		`hdtv`:                 "HDTV",
+		`hdrip`:                "HDRip",
+		`dvdrip`:               "DVD",
+	}), words(map[string]string{
+		`remux`: "Remux",
+		`dvd`:   "DVD",
+	})...)
+	codecs = append(tags(map[string]string{
+		`[xh][ .]?264`:      "H.264",
+		`[xh][ .]?265|hevc`: "H.265",
+		`xvid`:              "XviD",
+		`divx`:              "DivX",
+		`av1`:               "AV1",
+		`vp9`:               "VP9",
+	}), words(map[string]string{
+		`avc`: "H.264",
+	})...)
+	editions = words(map[string]string{
+		`director'?s[ .]cut`:               "Director's Cut",
+		`extended(?:[ .](?:edition|cut))?`: "Extended",
+		`unrated`:                          "Unrated",
+		`theatrical(?:[ .]cut)?`:           "Theatrical",
+		`remastered`:                       "Remastered",
+		`special[ .]edition`:               "Special Edition",
+		`ultimate[ .](?:edition|cut)`:      "Ultimate Edition",
+		`final[ .]cut`:                     "Final Cut",
+		`imax`:                             "IMAX",
+		`criterion(?:[ .]collection)?`:     "Criterion",
+		`anniversary[ .]edition`:           "Anniversary Edition",
+	})
+)
+
+// ParseMovie extracts the title, year, edition and quality of a movie from the
+// path of its file. If the filename has no year, the name of the directory
+// holding it is used instead, to support layouts like "Title (Year)/movie.mkv".
+func ParseMovie(path string) *MovieFile {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	m := parseMovieName(name)
+	if m.Year == 0 {
+		if dir := filepath.Base(filepath.Dir(path)); dir != "." && dir != "/" {
+			if d := parseMovieName(dir); d.Year != 0 {
+				m.Title, m.Year = d.Title, d.Year
+				if m.Edition == "" {
+					m.Edition = d.Edition
+				}
+			}
+		}
+	}
+	m.File = path
+	return m
+}
+
+func parseMovieName(name string) *MovieFile {
+	m := &MovieFile{}
+	// The release tags start at the first one that isn't a word, or after the
+	// year.
+	tail := len(name)
+	for _, list := range [][]movieTag{resolutions, sources, codecs} {
+		for _, tag := range list {
+			if i := tag.index(name, 0); !tag.word && i >= 0 && i < tail {
+				tail = i
+			}
+		}
+	}
+
+	// The year is the last one before the tags, so titles that are or start
+	// with a year, like "1917 (2019)" or "2001 A Space Odyssey 1968", work.
+	end, after := len(name), 0
+	for _, loc := range yearRe.FindAllStringIndex(name[:tail], -1) {
+		if loc[0] == 0 || isDigit(name[loc[0]-1]) || (loc[1] < len(name) && isDigit(name[loc[1]])) {
+			continue
+		}
+		m.Year, _ = strconv.Atoi(name[loc[0]:loc[1]])
+		end, after = loc[0], loc[1]
+	}
+	if m.Year != 0 {
+		tail = after
+	}
+
+	find := func(tags []movieTag) string {
+		var value string
+		start := len(name)
+		for _, tag := range tags {
+			from := 0
+			if tag.word {
+				from = tail
+			}
+			if i := tag.index(name, from); i >= 0 && i < start {
+				start, value = i, tag.value
+			}
+		}
+		if start < end {
+			end = start
+		}
+		return value
+	}
+	m.Resolution = find(resolutions)
+	m.Source = find(sources)
+	m.Codec = find(codecs)
+	m.Edition = find(editions)
+	if end == 0 {
+		end = len(name)
+	}
+	m.Title = cleanName(bracketedRe.ReplaceAllString(name[:end], ""))
+	if m.Title == "" {
+		m.Title = name
+	}
+	return m
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Tags returns a display string of the file's edition and quality, like
+// "Director's Cut 1080p BluRay H.264".
+func (m *MovieFile) Tags() string {
+	var tags []string
+	for _, tag := range []string{m.Edition, m.Resolution, m.Source, m.Codec} {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, " ")
+}
+
+// sortTitle returns the title used to sort movies, ignoring leading articles.
+func sortTitle(title string) string {
+	title = strings.ToLower(title)
+	for _, article := range []string{"the ", "a ", "an "} {
+		if strings.HasPrefix(title, article) {
+			return strings.TrimPrefix(title, article)
+		}
+	}
+	return title
+}
+
+// Movies groups files into movies by title and year, sorted by title.
+func Movies(files []File) []*Movie {
+	movies := make(map[string]*Movie)
+	var sorted []*Movie
+	for _, f := range files {
+		mf := ParseMovie(f.Path)
+		key := strings.ToLower(mf.Title) + "/" + strconv.Itoa(mf.Year)
+		movie := movies[key]
+		if movie == nil {
+			movie = &Movie{Title: mf.Title, Year: mf.Year}
+			movies[key] = movie
+			sorted = append(sorted, movie)
+		}
+		movie.Files = append(movie.Files, mf)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sortTitle(sorted[i].Title), sortTitle(sorted[j].Title)
+		if a != b {
+			return a < b
+		}
+		return sorted[i].Year < sorted[j].Year
+	})
+	return sorted
+}
@@ -0,0 +1,54 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMovie(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		title string
+		year  int
+		tags  string
+	}{
+		{"Movies/The.Matrix.1999.1080p.BluRay.x264-GROUP.mkv", "The Matrix", 1999, "1080p BluRay H.264"},
+		{"Movies/Alien (1979) [Director's Cut] 720p WEB-DL.mkv", "Alien", 1979, "Director's Cut 720p WEB-DL"},
+		{"Movies/Heat (1995)/heat.mkv", "Heat", 1995, ""},
+		{"Movies/1917 (2019).mkv", "1917", 2019, ""},
+		{"Movies/2001 A Space Odyssey 1968 2160p UHD Remux HEVC.mkv", "2001 A Space Odyssey", 1968, "2160p Remux H.265"},
+		{"Movies/Blade Runner 2049 (2017) Final Cut 1080p.mkv", "Blade Runner 2049", 2017, "Final Cut 1080p"},
+		{"Movies/Some.Movie.2010.EXTENDED.DVD.AVC.mkv", "Some Movie", 2010, "Extended DVD H.264"},
+		{"Movies/Some.Movie.720p.Extended.mkv", "Some Movie", 0, "Extended 720p"},
+		// Tags that are words are part of the title before the year.
+		{"Movies/Avc Story (2010).mkv", "Avc Story", 2010, ""},
+		{"Movies/The Final Cut (2004) 1080p.mkv", "The Final Cut", 2004, "1080p"},
+		{"Movies/Extended Family.mkv", "Extended Family", 0, ""},
+		{"Movies/Untitled.mkv", "Untitled", 0, ""},
+	} {
+		m := ParseMovie(test.path)
+		if m.Title != test.title || m.Year != test.year || m.Tags() != test.tags {
+			t.Errorf("ParseMovie(%q) = %q, %d, %q; want %q, %d, %q",
+				test.path, m.Title, m.Year, m.Tags(), test.title, test.year, test.tags)
+		}
+	}
+}
+
+func TestMovies(t *testing.T) {
+	files := []File{
+		{Path: "Movies/The Thing (1982) 1080p.mkv"},
+		{Path: "Movies/Alien (1979).mkv"},
+		{Path: "Movies/The Thing (2011).mkv"},
+		{Path: "Movies/the thing (1982) 720p.mkv"},
+	}
+	var got []string
+	for _, m := range Movies(files) {
+		for _, f := range m.Files {
+			got = append(got, m.Title+" "+f.Tags())
+		}
+	}
+	want := []string{"Alien ", "The Thing 1080p", "The Thing 720p", "The Thing "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Movies = %q, want %q", got, want)
+	}
+}
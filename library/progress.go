@@ -0,0 +1,203 @@
+package library
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// saveInterval limits how often frequent progress updates are written to disk.
+const saveInterval = 10 * time.Second
+
+// watchedFraction is how far into a file playback has to get for it to count
+// as watched.
+const watchedFraction = 0.9
+
+// Progress is how far playback of a file got. Times are in seconds.
+type Progress struct {
+	Position int       `json:"position"`
+	Length   int       `json:"length"`
+	Watched  bool      `json:"watched"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Resume returns where playback of the file should resume, or 0 if it was
+// barely started or already finished.
+func (p *Progress) Resume() int {
+	if p == nil || p.Watched || p.Position < 10 {
+		return 0
+	}
+	if p.Length > 0 && p.Position > p.Length-30 {
+		return 0
+	}
+	return p.Position
+}
+
+// ProgressStore records watch progress for library files, keyed by their path
+// relative to the library root. Build using OpenProgress().
+type ProgressStore struct {
+	sync.RWMutex
+	Files map[string]*Progress
+
+	filename string
+	saved    time.Time
+}
+
+// OpenProgress loads the progress saved in filename, or returns an empty store
+// if it does not exist yet.
+func OpenProgress(filename string) (*ProgressStore, error) {
+	p := &ProgressStore{
+		Files:    make(map[string]*Progress),
+		filename: filename,
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.Files); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Get returns the progress of file, or nil if it was never played.
+func (p *ProgressStore) Get(file string) *Progress {
+	p.RLock()
+	defer p.RUnlock()
+	if progress, ok := p.Files[file]; ok {
+		c := *progress
+		return &c
+	}
+	return nil
+}
+
+// Watched reports whether file has been watched.
+func (p *ProgressStore) Watched(file string) bool {
+	p.RLock()
+	defer p.RUnlock()
+	progress, ok := p.Files[file]
+	return ok && progress.Watched
+}
+
+// SetWatched marks file as watched or unwatched and saves the store.
+func (p *ProgressStore) SetWatched(file string, watched bool) error {
+	p.Lock()
+	progress := p.Files[file]
+	if progress == nil {
+		progress = new(Progress)
+		p.Files[file] = progress
+	}
+	progress.Watched = watched
+	if !watched {
+		progress.Position = 0
+	}
+	progress.Updated = time.Now()
+	p.Unlock()
+	return p.Save()
+}
+
+// Update records the playback position of file, marking it watched once
+// playback passes most of its length. The store is saved to disk if it hasn't
+// been recently.
+func (p *ProgressStore) Update(file string, position, length int) error {
+	p.Lock()
+	progress := p.Files[file]
+	if progress == nil {
+		progress = new(Progress)
+		p.Files[file] = progress
+	}
+	progress.Position = position
+	if length > 0 {
+		progress.Length = length
+	}
+	progress.Updated = time.Now()
+	save := time.Since(p.saved) >= saveInterval
+	if !progress.Watched && progress.Length > 0 && float64(position) >= watchedFraction*float64(progress.Length) {
+		progress.Watched = true
+		save = true
+	}
+	p.Unlock()
+	if save {
+		return p.Save()
+	}
+	return nil
+}
+
+// Save writes the store to disk, replacing the previous copy atomically.
+func (p *ProgressStore) Save() error {
+	p.Lock()
+	defer p.Unlock()
+	data, err := json.MarshalIndent(p.Files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p.filename); err != nil {
+		return err
+	}
+	p.saved = time.Now()
+	return nil
+}
+
+// UpNext returns the episode to watch next for every show that has been
+// started: the first unwatched episode after the last one watched, most
+// recently watched shows first.
+func (p *ProgressStore) UpNext(shows []*Show) []*Episode {
+	p.RLock()
+	defer p.RUnlock()
+	type next struct {
+		episode *Episode
+		updated time.Time
+	}
+	var upNext []next
+	for _, show := range shows {
+		var episodes []*Episode
+		for _, season := range show.Seasons {
+			episodes = append(episodes, season.Episodes...)
+		}
+		var candidate *Episode
+		var updated time.Time
+		for i, ep := range episodes {
+			progress, ok := p.Files[ep.File]
+			if !ok {
+				continue
+			}
+			if progress.Updated.After(updated) {
+				updated = progress.Updated
+			}
+			if !progress.Watched && progress.Position > 0 {
+				candidate = ep
+				continue
+			}
+			if progress.Watched {
+				candidate = nil
+				for _, after := range episodes[i+1:] {
+					if other, ok := p.Files[after.File]; !ok || !other.Watched {
+						candidate = after
+						break
+					}
+				}
+			}
+		}
+		if candidate != nil {
+			upNext = append(upNext, next{candidate, updated})
+		}
+	}
+	sort.SliceStable(upNext, func(i, j int) bool {
+		return upNext[i].updated.After(upNext[j].updated)
+	})
+	episodes := make([]*Episode, len(upNext))
+	for i, n := range upNext {
+		episodes[i] = n.episode
+	}
+	return episodes
+}
@@ -0,0 +1,219 @@
+package library
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Weights of the places a search term can match, best first.
+const (
+	titleWeight    = 4
+	episodeWeight  = 2
+	yearWeight     = 2
+	folderWeight   = 1
+	filenameWeight = 0.5
+
+	// prefixFactor scales the weight of terms that only match the start of a word.
+	prefixFactor = 0.5
+	// exactBonus is added when the query is the whole title.
+	exactBonus = 5
+)
+
+// foldTable maps accented and special letters to their plain ASCII spelling.
+var foldTable = make(map[rune]string)
+
+func init() {
+	for plain, accented := range map[string]string{
+		"a": "àáâãäåāăąǎ", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ",
+		"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏőǒ", "r": "ŕŗř",
+		"s": "śŝşšș", "t": "ţťŧț", "u": "ùúûüũūŭůűųǔ", "w": "ŵ",
+		"y": "ýÿŷ", "z": "źżž", "ae": "æ", "oe": "œ", "ss": "ß", "th": "þ",
+	} {
+		for _, r := range accented {
+			foldTable[r] = plain
+		}
+	}
+}
+
+// Fold lowercases s and strips diacritics, so "Amélie" and "AMELIE" compare
+// equal.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		r = unicode.ToLower(r)
+		if plain, ok := foldTable[r]; ok {
+			b.WriteString(plain)
+		} else if !unicode.Is(unicode.Mn, r) {
+			// Combining marks are dropped, for names stored decomposed.
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Tokenize folds s and splits it into words. Apostrophes are dropped rather
+// than splitting, so "Grey's" is the single word "greys".
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.NewReplacer("'", "", "’", "").Replace(Fold(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchResult is a library file matching a search. Kind is "movie",
+// "episode" or "file".
+type SearchResult struct {
+	File    string  `json:"file"`
+	Kind    string  `json:"kind"`
+	Title   string  `json:"title"`
+	Episode string  `json:"episode,omitempty"`
+	Year    int     `json:"year,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+type posting struct {
+	doc    int
+	weight float64
+}
+
+// Search is an inverted index of the library for full-text search. Build
+// using NewSearch(), it is not updated when the library changes.
+type Search struct {
+	docs     []SearchResult
+	titles   []string
+	words    []string
+	postings map[string][]posting
+}
+
+// NewSearch indexes files by their parsed title, show and episode names,
+// year, folders and filename. The kind of the library holding a file tells
+// how it is named.
+func NewSearch(files []File, libraries []*Library) *Search {
+	s := &Search{postings: make(map[string][]posting)}
+	for _, f := range files {
+		doc := len(s.docs)
+		weights := make(map[string]float64)
+		add := func(text string, weight float64) {
+			for _, word := range Tokenize(text) {
+				if weight > weights[word] {
+					weights[word] = weight
+				}
+			}
+		}
+		result := SearchResult{File: f.Path, Kind: "file"}
+		var kind, rel string
+		for _, l := range libraries {
+			if r, ok := l.Rel(f.Path); ok {
+				kind, rel = l.Kind, r
+				add(l.Name, folderWeight)
+				break
+			}
+		}
+		switch kind {
+		case MoviesKind, ConcertsKind:
+			m := ParseMovie(rel)
+			result.Kind, result.Title, result.Year = "movie", m.Title, m.Year
+		case ShowsKind:
+			ep := ParseEpisode(rel)
+			result.Kind, result.Title, result.Episode = "episode", ep.Show, ep.Name()
+			if !ep.AirDate.IsZero() {
+				result.Year = ep.AirDate.Year()
+			}
+			add(ep.Title, episodeWeight)
+			add(strings.SplitN(ep.Name(), " - ", 2)[0], episodeWeight)
+		}
+		name := filepath.Base(f.Path)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		if result.Title == "" {
+			result.Title = cleanName(name)
+		}
+		add(name, filenameWeight)
+		add(filepath.Dir(f.Path), folderWeight)
+		if result.Year != 0 {
+			add(strconv.Itoa(result.Year), yearWeight)
+		}
+		add(result.Title, titleWeight)
+		for word, weight := range weights {
+			s.postings[word] = append(s.postings[word], posting{doc, weight})
+		}
+		s.docs = append(s.docs, result)
+		s.titles = append(s.titles, strings.Join(Tokenize(result.Title), " "))
+	}
+	s.words = make([]string, 0, len(s.postings))
+	for word := range s.postings {
+		s.words = append(s.words, word)
+	}
+	sort.Strings(s.words)
+	return s
+}
+
+// Len returns the number of files in the index.
+func (s *Search) Len() int {
+	return len(s.docs)
+}
+
+// match scores every file holding word, or a word it is the start of.
+func (s *Search) match(word string) map[int]float64 {
+	scores := make(map[int]float64)
+	for i := sort.SearchStrings(s.words, word); i < len(s.words) && strings.HasPrefix(s.words[i], word); i++ {
+		factor := 1.0
+		if s.words[i] != word {
+			factor = prefixFactor
+		}
+		for _, p := range s.postings[s.words[i]] {
+			if score := p.weight * factor; score > scores[p.doc] {
+				scores[p.doc] = score
+			}
+		}
+	}
+	return scores
+}
+
+// Query returns up to limit files matching every word of query, best matches
+// first. Words also match the start of longer ones, so results show up while
+// the query is being typed.
+func (s *Search) Query(query string, limit int) []SearchResult {
+	words := Tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+	var scores map[int]float64
+	for _, word := range words {
+		matches := s.match(word)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for doc, score := range scores {
+			if m, ok := matches[doc]; ok {
+				scores[doc] = score + m
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+	whole := strings.Join(words, " ")
+	results := make([]SearchResult, 0, len(scores))
+	for doc, score := range scores {
+		if s.titles[doc] == whole {
+			score += exactBonus
+		}
+		result := s.docs[doc]
+		result.Score = score
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.File < b.File
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
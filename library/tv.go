@@ -0,0 +1,260 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Episode is a single TV episode file, as parsed from its name and location.
+type Episode struct {
+	File     string    `json:"file"`
+	Show     string    `json:"show"`
+	Season   int       `json:"season"`
+	Episodes []int     `json:"episodes,omitempty"`
+	AirDate  time.Time `json:"airDate"`
+	Title    string    `json:"title"`
+}
+
+// Season groups the episodes of a show. Seasons that can't be determined from
+// the filename or folder have an empty Name.
+type Season struct {
+	Number   int        `json:"number"`
+	Name     string     `json:"name"`
+	Episodes []*Episode `json:"episodes"`
+}
+
+// Show is a TV show and all of its seasons.
+type Show struct {
+	Name    string    `json:"name"`
+	Seasons []*Season `json:"seasons"`
+}
+
+var (
+	seasonEpisodeRe = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})((?:[ ._-]*-?[ ._-]*e\d{1,3}|-\d{1,3}(?:[ ._-]|$))*)`)
+	crossEpisodeRe  = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})((?:[ ._-]*x\d{2,3})*)(?:[^a-z0-9]|$)`)
+	airDateRe       = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d{2})[ ._-](\d{2})[ ._-](\d{2})(?:[^0-9]|$)`)
+	dashEpisodeRe   = regexp.MustCompile(`(?:^|[ ._])-[ ._]*(\d{1,2})(\d{2})[ ._]*-(?:[ ._]|$)`)
+	bareEpisodeRe   = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:episode|ep)[ ._-]*(\d{1,3})(?:[^0-9]|$)`)
+	extraEpisodeRe  = regexp.MustCompile(`(?i)[ex-](\d{1,3})`)
+	seasonDirRe     = regexp.MustCompile(`(?i)^(?:season|series|s)[ ._-]*(\d{1,4})$`)
+	specialsDirRe   = regexp.MustCompile(`(?i)^(?:specials?|extras)$`)
+	separatorRe     = regexp.MustCompile(`[._\s]+`)
+	releaseTagRe    = regexp.MustCompile(`(?i)[ ._\-\[(](?:480p|576p|720p|1080[pi]|2160p|4k|hdtv|web[ .-]?dl|webrip|bluray|brrip|bdrip|dvdrip|x264|x265|h[ .]?264|h[ .]?265|hevc|xvid|proper|repack)(?:[ ._\-\])]|$)`)
+)
+
+// ParseEpisode extracts episode information from the path of a file, relative
+// to the folder that holds all the shows. The show name is taken from the
+// first directory if there is one, otherwise from the filename.
+func ParseEpisode(path string) *Episode {
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(path)), "/")
+	if len(dirs) == 1 && dirs[0] == "." {
+		dirs = nil
+	}
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	ep := &Episode{File: path, Season: -1}
+	var show, rest string
+	if m := seasonEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		ep.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		ep.Episodes = episodeNumbers(name[m[4]:m[5]], name[m[6]:m[7]])
+		show, rest = name[:m[0]], name[m[1]:]
+	} else if m := crossEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		ep.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		ep.Episodes = episodeNumbers(name[m[4]:m[5]], name[m[6]:m[7]])
+		show, rest = name[:m[0]], name[m[7]:]
+	} else if m := airDateRe.FindStringSubmatchIndex(name); m != nil {
+		date, err := time.Parse("2006-01-02", fmt.Sprintf("%s-%s-%s", name[m[2]:m[3]], name[m[4]:m[5]], name[m[6]:m[7]]))
+		if err == nil {
+			ep.AirDate = date
+			ep.Season = date.Year()
+			show, rest = name[:m[0]], name[m[7]:]
+		} else {
+			rest = name
+		}
+	} else if m := dashEpisodeRe.FindStringSubmatchIndex(name); m != nil && !isYear(name[m[2]:m[5]]) {
+		// "Show - 101 - Title" is season 1, episode 1.
+		ep.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		ep.Episodes = episodeNumbers(name[m[4]:m[5]], "")
+		show, rest = name[:m[0]], name[m[1]:]
+	} else if m := bareEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		// "Episode 5", whose season comes from the folder.
+		ep.Episodes = episodeNumbers(name[m[2]:m[3]], "")
+		show, rest = name[:m[0]], name[m[3]:]
+	} else {
+		rest = name
+	}
+
+	if len(dirs) > 0 {
+		ep.Show = dirs[0]
+	} else {
+		ep.Show = cleanName(show)
+	}
+	if ep.Show == "" {
+		ep.Show = cleanName(rest)
+	}
+	ep.Title = cleanName(rest)
+	if ep.Title == "" && len(ep.Episodes) == 0 && ep.AirDate.IsZero() {
+		ep.Title = name
+	}
+
+	for i := len(dirs) - 1; i > 0 && ep.Season < 0; i-- {
+		if m := seasonDirRe.FindStringSubmatch(dirs[i]); m != nil {
+			ep.Season, _ = strconv.Atoi(m[1])
+		} else if specialsDirRe.MatchString(dirs[i]) {
+			ep.Season = 0
+		}
+	}
+	return ep
+}
+
+// isYear reports whether digits look like a year rather than an episode.
+func isYear(digits string) bool {
+	return len(digits) == 4 && (strings.HasPrefix(digits, "19") || strings.HasPrefix(digits, "20"))
+}
+
+func episodeNumbers(first, extra string) []int {
+	n, _ := strconv.Atoi(first)
+	episodes := []int{n}
+	for _, m := range extraEpisodeRe.FindAllStringSubmatch(extra, -1) {
+		n, _ := strconv.Atoi(m[1])
+		episodes = append(episodes, n)
+	}
+	return episodes
+}
+
+// cleanName turns a scene-style name like "Some.Show.720p.HDTV" into "Some Show".
+func cleanName(s string) string {
+	if loc := releaseTagRe.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]]
+	}
+	s = separatorRe.ReplaceAllString(s, " ")
+	return strings.Trim(s, " -[]()")
+}
+
+// SeasonName returns the display name of the episode's season, or an empty
+// string if it isn't known.
+func (ep *Episode) SeasonName() string {
+	switch {
+	case ep.Season < 0:
+		return ""
+	case ep.Season == 0:
+		return "Specials"
+	case !ep.AirDate.IsZero():
+		return strconv.Itoa(ep.Season)
+	}
+	return fmt.Sprintf("Season %d", ep.Season)
+}
+
+// Name returns a display name for the episode, like "S01E02 - Title".
+func (ep *Episode) Name() string {
+	var code string
+	if len(ep.Episodes) > 0 {
+		var b strings.Builder
+		if ep.Season >= 0 {
+			fmt.Fprintf(&b, "S%02d", ep.Season)
+		}
+		for _, n := range ep.Episodes {
+			fmt.Fprintf(&b, "E%02d", n)
+		}
+		code = b.String()
+	} else if !ep.AirDate.IsZero() {
+		code = ep.AirDate.Format("2006-01-02")
+	}
+	switch {
+	case code == "":
+		return ep.Title
+	case ep.Title == "":
+		return code
+	}
+	return code + " - " + ep.Title
+}
+
+// Less orders episodes by season, episode number, air date and then filename.
+func (ep *Episode) Less(other *Episode) bool {
+	if ep.Season != other.Season {
+		return ep.Season < other.Season
+	}
+	if len(ep.Episodes) > 0 && len(other.Episodes) > 0 && ep.Episodes[0] != other.Episodes[0] {
+		return ep.Episodes[0] < other.Episodes[0]
+	}
+	if !ep.AirDate.Equal(other.AirDate) {
+		return ep.AirDate.Before(other.AirDate)
+	}
+	return ep.File < other.File
+}
+
+// Shows groups the files under folders into shows and seasons, sorted by name
+// and episode order. Shows spread over several folders are merged.
+func Shows(folders []string, files []File) []*Show {
+	shows := make(map[string]*Show)
+	seasons := make(map[*Show]map[string]*Season)
+	l := &Library{Paths: folders}
+	for _, f := range files {
+		rel, ok := l.Rel(f.Path)
+		if !ok {
+			continue
+		}
+		ep := ParseEpisode(rel)
+		ep.File = f.Path
+		key := strings.ToLower(ep.Show)
+		show := shows[key]
+		if show == nil {
+			show = &Show{Name: ep.Show}
+			shows[key] = show
+			seasons[show] = make(map[string]*Season)
+		}
+		name := ep.SeasonName()
+		season := seasons[show][name]
+		if season == nil {
+			season = &Season{Number: ep.Season, Name: name}
+			seasons[show][name] = season
+			show.Seasons = append(show.Seasons, season)
+		}
+		season.Episodes = append(season.Episodes, ep)
+	}
+	sorted := make([]*Show, 0, len(shows))
+	for _, show := range shows {
+		sort.Slice(show.Seasons, func(i, j int) bool {
+			return show.Seasons[i].Number < show.Seasons[j].Number
+		})
+		for _, season := range show.Seasons {
+			episodes := season.Episodes
+			sort.Slice(episodes, func(i, j int) bool {
+				return episodes[i].Less(episodes[j])
+			})
+		}
+		sorted = append(sorted, show)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+	return sorted
+}
+
+// NextEpisodes returns up to n episodes that follow file in its show, rolling
+// over into the following seasons.
+func NextEpisodes(shows []*Show, file string, n int) []*Episode {
+	for _, show := range shows {
+		var episodes []*Episode
+		for _, season := range show.Seasons {
+			episodes = append(episodes, season.Episodes...)
+		}
+		for i, ep := range episodes {
+			if ep.File != file {
+				continue
+			}
+			next := episodes[i+1:]
+			if len(next) > n {
+				next = next[:n]
+			}
+			return next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEpisode(t *testing.T) {
+	for _, test := range []struct {
+		path     string
+		show     string
+		season   int
+		episodes []int
+		title    string
+	}{
+		{"Some.Show.S01E02.720p.HDTV.x264.mkv", "Some Show", 1, []int{2}, ""},
+		{"Show/Season 1/Show.S01E02E03.Title.mkv", "Show", 1, []int{2, 3}, "Title"},
+		{"Show.S01E01-E02.mkv", "Show", 1, []int{1, 2}, ""},
+		{"Show.S01E01-02.mkv", "Show", 1, []int{1, 2}, ""},
+		{"Show.S01E01-02.Title.720p.mkv", "Show", 1, []int{1, 2}, "Title"},
+		{"Show.S01E01-720p.mkv", "Show", 1, []int{1}, ""},
+		{"Show.S01E01-Title.mkv", "Show", 1, []int{1}, "Title"},
+		{"Show/Show 2x05 Title.avi", "Show", 2, []int{5}, "Title"},
+		{"Show/Season 2/Episode 5.mkv", "Show", 2, []int{5}, ""},
+		{"Show/Season 2/Episode 5 - The Title.mkv", "Show", 2, []int{5}, "The Title"},
+		{"Show/Series 3/Ep.12.mkv", "Show", 3, []int{12}, ""},
+		{"Show/Show - 101 - Pilot.mkv", "Show", 1, []int{1}, "Pilot"},
+		{"Show - 1012 - Finale.mkv", "Show", 10, []int{12}, "Finale"},
+		{"Show/Specials/Behind the Scenes.mkv", "Show", 0, nil, "Behind the Scenes"},
+		{"Show/Show - 1999 - Concert.mkv", "Show", -1, nil, "Show - 1999 - Concert"},
+		{"Show/Sleep 3.mkv", "Show", -1, nil, "Sleep 3"},
+	} {
+		ep := ParseEpisode(test.path)
+		if ep.Show != test.show || ep.Season != test.season || !reflect.DeepEqual(ep.Episodes, test.episodes) || ep.Title != test.title {
+			t.Errorf("ParseEpisode(%q) = show %q, season %d, episodes %v, title %q; want %q, %d, %v, %q",
+				test.path, ep.Show, ep.Season, ep.Episodes, ep.Title, test.show, test.season, test.episodes, test.title)
+		}
+	}
+}
+
+func TestParseEpisodeAirDate(t *testing.T) {
+	ep := ParseEpisode("Daily Show/Daily.Show.2021.03.04.Guest.mkv")
+	if ep.Season != 2021 || ep.AirDate.Format("2006-01-02") != "2021-03-04" || ep.Title != "Guest" {
+		t.Errorf("got season %d, air date %v, title %q", ep.Season, ep.AirDate, ep.Title)
+	}
+}
+
+func TestNextEpisodes(t *testing.T) {
+	files := []File{
+		{Path: "TV/Show/Season 2/Episode 1.mkv"},
+		{Path: "TV/Show/Season 1/Show - 102 - Second.mkv"},
+		{Path: "TV/Show/Season 1/Show - 101 - Pilot.mkv"},
+		{Path: "TV/Show/Season 2/Episode 2.mkv"},
+	}
+	shows := Shows([]string{"TV"}, files)
+	var next []string
+	for _, ep := range NextEpisodes(shows, "TV/Show/Season 1/Show - 101 - Pilot.mkv", 3) {
+		next = append(next, ep.File)
+	}
+	want := []string{
+		"TV/Show/Season 1/Show - 102 - Second.mkv",
+		"TV/Show/Season 2/Episode 1.mkv",
+		"TV/Show/Season 2/Episode 2.mkv",
+	}
+	if !reflect.DeepEqual(next, want) {
+		t.Errorf("NextEpisodes = %v, want %v", next, want)
+	}
+}
@@ -0,0 +1,187 @@
+package library
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// maxDelay bounds how long a steady stream of changes can hold back a rescan.
+const maxDelay = 30 * time.Second
+
+// Watcher keeps an Index up to date by watching its directories with inotify.
+// Build using Index.Watch().
+type Watcher struct {
+	idx      *Index
+	delay    time.Duration
+	onChange func()
+	fd       int
+	file     *os.File
+
+	mu      sync.Mutex
+	watches map[int]string
+	dirs    map[string]int
+	dirty   map[string]bool
+}
+
+// Watch starts watching every directory in the index. Changes are collected
+// until delay passes without any new ones, then the affected directories are
+// rescanned and onChange is called.
+func (idx *Index) Watch(delay time.Duration, onChange func()) (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		idx:      idx,
+		delay:    delay,
+		onChange: onChange,
+		fd:       fd,
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		watches:  make(map[int]string),
+		dirs:     make(map[string]int),
+		dirty:    make(map[string]bool),
+	}
+	w.sync()
+	events := make(chan struct{}, 1)
+	go w.read(events)
+	go w.debounce(events)
+	return w, nil
+}
+
+// Close stops watching for changes.
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// sync adds watches for directories new to the index and forgets ones that
+// have gone away.
+func (w *Watcher) sync() {
+	dirs := w.idx.Directories()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	current := make(map[string]bool)
+	for _, dir := range dirs {
+		current[dir] = true
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.idx.Root, dir), watchMask)
+		if err != nil {
+			log.Printf("error watching %s: %v", dir, err)
+			continue
+		}
+		if old, ok := w.watches[wd]; ok {
+			delete(w.dirs, old)
+		}
+		w.watches[wd] = dir
+		w.dirs[dir] = wd
+	}
+	for dir, wd := range w.dirs {
+		if !current[dir] {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+			delete(w.dirs, dir)
+		}
+	}
+}
+
+func (w *Watcher) read(events chan<- struct{}) {
+	defer close(events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("error watching library: %v", err)
+			}
+			return
+		}
+		w.mu.Lock()
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				for dir := range w.dirs {
+					w.dirty[dir] = true
+				}
+				continue
+			}
+			dir, ok := w.watches[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.watches, int(event.Wd))
+				delete(w.dirs, dir)
+				continue
+			}
+			w.dirty[dir] = true
+		}
+		w.mu.Unlock()
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *Watcher) debounce(events <-chan struct{}) {
+	var first time.Time
+	timer := time.NewTimer(w.delay)
+	timer.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			if first.IsZero() {
+				first = time.Now()
+			}
+			wait := w.delay
+			if remaining := maxDelay - time.Since(first); remaining < wait {
+				wait = remaining
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+		case <-timer.C:
+			first = time.Time{}
+			w.flush()
+		}
+	}
+}
+
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	dirs := make([]string, 0, len(w.dirty))
+	for dir := range w.dirty {
+		dirs = append(dirs, dir)
+	}
+	w.dirty = make(map[string]bool)
+	w.mu.Unlock()
+	before := w.idx.LastModified()
+	if err := w.idx.Refresh(dirs...); err != nil {
+		log.Printf("error scanning files: %v", err)
+		return
+	}
+	w.sync()
+	if w.onChange != nil && !w.idx.LastModified().Equal(before) {
+		w.onChange()
+	}
+}
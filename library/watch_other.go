@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package library
+
+import (
+	"errors"
+	"time"
+)
+
+// Watcher keeps an Index up to date by watching its directories. It is only
+// supported on Linux.
+type Watcher struct{}
+
+// Watch is not supported on this platform.
+func (idx *Index) Watch(delay time.Duration, onChange func()) (*Watcher, error) {
+	return nil, errors.New("watching the library is only supported on linux")
+}
+
+// Close does nothing.
+func (w *Watcher) Close() error {
+	return nil
+}
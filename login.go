@@ -35,6 +35,38 @@ func hasLoginCookie(r *http.Request) bool {
 	return false
 }
 
-func passwordProtectedDownload(r *http.Request) bool {
-	return strings.HasPrefix(r.URL.Path, "/download") && r.FormValue("password") == *password
+func passwordProtectedDownload(r *http.Request, password string) bool {
+	return strings.HasPrefix(r.URL.Path, "/download") && r.FormValue("password") == password
+}
+
+// downloadToken returns a token that lets whoever has it download file
+// without logging in, for TVs that can't.
+func downloadToken(file string) string {
+	token, err := bakery.Encode("download", file)
+	if err != nil {
+		log.Printf("error making download token: %v", err)
+	}
+	return token
+}
+
+func tokenProtectedDownload(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/download") || r.FormValue("token") == "" {
+		return false
+	}
+	var file string
+	if err := bakery.Decode("download", r.FormValue("token"), &file); err != nil {
+		log.Printf("error decoding download token: %v", err)
+		return false
+	}
+	return file == r.FormValue("file")
+}
+
+func passwordProtectedAPI(r *http.Request, password string) bool {
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return false
+	}
+	if _, pass, ok := r.BasicAuth(); ok {
+		return pass == password
+	}
+	return r.Header.Get("X-Pilot-Password") == password
 }
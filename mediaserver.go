@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etherealmachine/pilot/library"
+	"github.com/etherealmachine/pilot/upnp"
+)
+
+// Where the services of the UPnP MediaServer are described and called.
+const (
+	contentDirectoryPath  = "/upnp/ContentDirectory"
+	connectionManagerPath = "/upnp/ConnectionManager"
+)
+
+// containsRe matches the strings a search criteria looks for, as in
+// dc:title contains "matrix".
+var containsRe = regexp.MustCompile(`contains\s+"((?:[^"\\]|\\.)*)"`)
+
+func mediaServices() []upnp.Service {
+	var services []upnp.Service
+	for _, svc := range []struct{ typ, path string }{
+		{upnp.ContentDirectory, contentDirectoryPath},
+		{upnp.ConnectionManager, connectionManagerPath},
+	} {
+		id := svc.path[strings.LastIndex(svc.path, "/")+1:]
+		services = append(services, upnp.Service{
+			ServiceType: svc.typ,
+			ServiceID:   "urn:upnp-org:serviceId:" + id,
+			SCPDURL:     svc.path + ".xml",
+			ControlURL:  svc.path + "/control",
+			EventSubURL: svc.path + "/event",
+		})
+	}
+	return services
+}
+
+// cdsObject is a folder or video of the content directory. The ID of an
+// object is the path of escaped names to it from the root, "0", so its
+// parent's is the part before the last slash.
+type cdsObject struct {
+	id       string
+	title    string
+	class    string
+	date     string
+	file     *library.File
+	children []*cdsObject
+}
+
+// add adds the child called name, unique among the children of o.
+func (o *cdsObject) add(name, title, class string) *cdsObject {
+	child := &cdsObject{id: o.id + "/" + url.PathEscape(name), title: title, class: class}
+	o.children = append(o.children, child)
+	return child
+}
+
+func (o *cdsObject) parentID() string {
+	if i := strings.LastIndex(o.id, "/"); i >= 0 {
+		return o.id[:i]
+	}
+	return "-1"
+}
+
+// find returns the object called id below o, or nil.
+func (o *cdsObject) find(id string) *cdsObject {
+	if o.id == id {
+		return o
+	}
+	for _, c := range o.children {
+		if id == c.id || strings.HasPrefix(id, c.id+"/") {
+			return c.find(id)
+		}
+	}
+	return nil
+}
+
+// videos returns every video below o.
+func (o *cdsObject) videos() []*cdsObject {
+	if o.file != nil {
+		return []*cdsObject{o}
+	}
+	var videos []*cdsObject
+	for _, c := range o.children {
+		videos = append(videos, c.videos()...)
+	}
+	return videos
+}
+
+// contentTree is the content directory as of a change to the library and a
+// configuration, kept as TVs browse it a page at a time and building it reads
+// every library.
+type contentTree struct {
+	modified time.Time
+	cfg      *Config
+	root     *cdsObject
+	videos   map[string]*cdsObject
+}
+
+// content returns the content directory, built again only once the library
+// or configuration changed.
+func (s *server) content() (*cdsObject, map[string]*cdsObject) {
+	modified, cfg := s.Library.LastModified(), s.config()
+	s.RLock()
+	t := s.cds
+	s.RUnlock()
+	if t == nil || !t.modified.Equal(modified) || t.cfg != cfg {
+		t = &contentTree{modified: modified, cfg: cfg}
+		t.root, t.videos = s.buildContent()
+		s.Lock()
+		s.cds = t
+		s.Unlock()
+	}
+	return t.root, t.videos
+}
+
+// buildContent returns the content directory, a folder per library holding
+// its movies or shows and seasons, laid out like the library pages, along
+// with the video object of every file.
+func (s *server) buildContent() (*cdsObject, map[string]*cdsObject) {
+	root := &cdsObject{id: "0", title: s.service.Name, class: upnp.FolderClass}
+	videos := make(map[string]*cdsObject)
+	addVideo := func(parent *cdsObject, file, title, class, date string) {
+		f := s.Library.Get(file)
+		if f == nil {
+			return
+		}
+		o := parent.add(file, title, class)
+		o.file, o.date = f, date
+		videos[file] = o
+	}
+	for _, l := range s.libraries() {
+		c := s.libraryContents(l)
+		folder := root.add(l.Name, l.Name, upnp.FolderClass)
+		for _, m := range c.Movies {
+			for _, mf := range m.Files {
+				title, date := m.Title, ""
+				if m.Year > 0 {
+					title += fmt.Sprintf(" (%d)", m.Year)
+					date = fmt.Sprintf("%d-01-01", m.Year)
+				}
+				if len(m.Files) > 1 && mf.Tags() != "" {
+					title += " " + mf.Tags()
+				}
+				addVideo(folder, mf.File, title, upnp.MovieClass, date)
+			}
+		}
+		for _, show := range c.Shows {
+			showFolder := folder.add(show.Name, show.Name, upnp.FolderClass)
+			for _, season := range show.Seasons {
+				// Episodes of an unknown season are listed with the show.
+				seasonFolder := showFolder
+				if season.Name != "" {
+					seasonFolder = showFolder.add(season.Name, season.Name, upnp.FolderClass)
+				}
+				for _, ep := range season.Episodes {
+					var date string
+					if !ep.AirDate.IsZero() {
+						date = ep.AirDate.Format("2006-01-02")
+					}
+					addVideo(seasonFolder, ep.File, ep.Name(), upnp.VideoClass, date)
+				}
+			}
+		}
+		for _, group := range c.Groups {
+			groupFolder := folder
+			if group.Name != "" {
+				groupFolder = folder.add(group.Name, group.Name, upnp.FolderClass)
+			}
+			for _, file := range group.Files {
+				addVideo(groupFolder, file, titleize(file), upnp.VideoClass, "")
+			}
+		}
+	}
+	return root, videos
+}
+
+// MediaServerHandler serves the services of pilot as a UPnP MediaServer:
+// their descriptions, and their actions over SOAP.
+func (s *server) MediaServerHandler(w http.ResponseWriter, r *http.Request) {
+	if s.service == nil || !s.config().Discovery.MediaServer {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case contentDirectoryPath + ".xml":
+		serveSCPD(w, upnp.ContentDirectorySCPD)
+	case connectionManagerPath + ".xml":
+		serveSCPD(w, upnp.ConnectionManagerSCPD)
+	case contentDirectoryPath + "/control":
+		s.control(w, r, s.contentDirectory)
+	case connectionManagerPath + "/control":
+		s.control(w, r, s.connectionManager)
+	case contentDirectoryPath + "/event", connectionManagerPath + "/event":
+		// The library doesn't change often enough for TVs to need telling.
+		http.Error(w, "events are not supported", http.StatusNotImplemented)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveSCPD(w http.ResponseWriter, scpd string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(scpd))
+}
+
+// control answers the SOAP action r calls with what f returns.
+func (s *server) control(w http.ResponseWriter, r *http.Request, f func(r *http.Request, a *upnp.Action) ([]upnp.Arg, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "actions must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	a, err := upnp.ReadAction(r)
+	if err != nil {
+		log.Printf("error reading upnp action: %v", err)
+		upnp.WriteError(w, upnp.ErrInvalidAction)
+		return
+	}
+	args, err := f(r, a)
+	if err != nil {
+		log.Printf("error answering upnp action %s: %v", a.Name, err)
+		upnp.WriteError(w, err)
+		return
+	}
+	upnp.WriteResponse(w, a, args...)
+}
+
+func (s *server) contentDirectory(r *http.Request, a *upnp.Action) ([]upnp.Arg, error) {
+	switch a.Name {
+	case "GetSearchCapabilities":
+		return []upnp.Arg{{Name: "SearchCaps", Value: "dc:title"}}, nil
+	case "GetSortCapabilities":
+		return []upnp.Arg{{Name: "SortCaps", Value: ""}}, nil
+	case "GetSystemUpdateID":
+		return []upnp.Arg{{Name: "Id", Value: s.updateID()}}, nil
+	case "Browse":
+		root, _ := s.content()
+		o := root.find(a.Args["ObjectID"])
+		if o == nil {
+			return nil, upnp.ErrNoSuchObject
+		}
+		switch a.Args["BrowseFlag"] {
+		case "BrowseMetadata":
+			return s.browseResult(r, a, []*cdsObject{o})
+		case "BrowseDirectChildren":
+			return s.browseResult(r, a, o.children)
+		}
+		return nil, upnp.ErrInvalidArgs
+	case "Search":
+		root, videos := s.content()
+		container := root.find(a.Args["ContainerID"])
+		if container == nil {
+			return nil, upnp.ErrNoSuchObject
+		}
+		criteria := strings.TrimSpace(a.Args["SearchCriteria"])
+		if criteria == "" {
+			return nil, upnp.ErrBadCriteria
+		}
+		// Only videos are searched, folders are never found.
+		if strings.Contains(criteria, "object.container") && !strings.Contains(criteria, "object.item") {
+			return s.browseResult(r, a, nil)
+		}
+		var terms []string
+		for _, m := range containsRe.FindAllStringSubmatch(criteria, -1) {
+			terms = append(terms, strings.ReplaceAll(m[1], `\"`, `"`))
+		}
+		if len(terms) == 0 {
+			return s.browseResult(r, a, container.videos())
+		}
+		// Every match is filtered by container before browseResult picks the
+		// page asked for.
+		var found []*cdsObject
+		for _, result := range s.searchIndex().Query(strings.Join(terms, " "), 0) {
+			o := videos[result.File]
+			if o != nil && (container == root || strings.HasPrefix(o.id, container.id+"/")) {
+				found = append(found, o)
+			}
+		}
+		return s.browseResult(r, a, found)
+	}
+	return nil, upnp.ErrInvalidAction
+}
+
+// updateID changes whenever the library does.
+func (s *server) updateID() string {
+	return strconv.FormatUint(uint64(uint32(s.Library.LastModified().Unix())), 10)
+}
+
+// browseResult returns the page of objects a asks for in DIDL-Lite, with
+// videos served from /download on the address the TV reached pilot at.
+func (s *server) browseResult(r *http.Request, a *upnp.Action, objects []*cdsObject) ([]upnp.Arg, error) {
+	total := len(objects)
+	start, _ := strconv.Atoi(a.Args["StartingIndex"])
+	count, _ := strconv.Atoi(a.Args["RequestedCount"])
+	if start < 0 || count < 0 {
+		return nil, upnp.ErrInvalidArgs
+	}
+	if start > total {
+		start = total
+	}
+	objects = objects[start:]
+	if count > 0 && count < len(objects) {
+		objects = objects[:count]
+	}
+	var didl upnp.DIDL
+	for _, o := range objects {
+		if o.file == nil {
+			didl.Containers = append(didl.Containers, upnp.Container{
+				ID:         o.id,
+				ParentID:   o.parentID(),
+				ChildCount: len(o.children),
+				Title:      o.title,
+				Class:      o.class,
+			})
+			continue
+		}
+		didl.Items = append(didl.Items, upnp.Item{
+			ID:       o.id,
+			ParentID: o.parentID(),
+			Title:    o.title,
+			Class:    o.class,
+			Date:     o.date,
+			Res: []upnp.Res{{
+				ProtocolInfo: upnp.ProtocolInfo(o.file.Path),
+				Size:         o.file.Size,
+				URL:          s.mediaURL(r, o.file.Path),
+			}},
+		})
+	}
+	result, err := didl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return []upnp.Arg{
+		{Name: "Result", Value: result},
+		{Name: "NumberReturned", Value: strconv.Itoa(len(objects))},
+		{Name: "TotalMatches", Value: strconv.Itoa(total)},
+		{Name: "UpdateID", Value: s.updateID()},
+	}, nil
+}
+
+// mediaURL returns the URL a TV that sent r downloads file from.
+func (s *server) mediaURL(r *http.Request, file string) string {
+	return "http://" + r.Host + s.streamPath(file)
+}
+
+func (s *server) connectionManager(r *http.Request, a *upnp.Action) ([]upnp.Arg, error) {
+	switch a.Name {
+	case "GetProtocolInfo":
+		var exts, infos []string
+		for ext := range library.Video {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		for _, ext := range exts {
+			infos = append(infos, upnp.ProtocolInfo(ext))
+		}
+		return []upnp.Arg{
+			{Name: "Source", Value: strings.Join(infos, ",")},
+			{Name: "Sink", Value: ""},
+		}, nil
+	case "GetCurrentConnectionIDs":
+		return []upnp.Arg{{Name: "ConnectionIDs", Value: "0"}}, nil
+	case "GetCurrentConnectionInfo":
+		if a.Args["ConnectionID"] != "0" {
+			return nil, upnp.ErrInvalidArgs
+		}
+		return []upnp.Arg{
+			{Name: "RcsID", Value: "-1"},
+			{Name: "AVTransportID", Value: "-1"},
+			{Name: "ProtocolInfo", Value: ""},
+			{Name: "PeerConnectionManager", Value: ""},
+			{Name: "PeerConnectionID", Value: "-1"},
+			{Name: "Direction", Value: "Output"},
+			{Name: "Status", Value: "OK"},
+		}, nil
+	}
+	return nil, upnp.ErrInvalidAction
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -11,22 +13,39 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/etherealmachine/pilot/cec"
-	"github.com/etherealmachine/pilot/vlcctrl"
+	"github.com/etherealmachine/pilot/discovery"
+	"github.com/etherealmachine/pilot/library"
+	"github.com/etherealmachine/pilot/player"
+	"github.com/etherealmachine/pilot/playlist"
+	"github.com/etherealmachine/pilot/upnp"
+	"github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
+	configFile = flag.String("config", "", "JSON configuration file, re-read on SIGHUP. Flags given on the command line override it.")
+
 	root     = flag.String("root", ".", "Root folder to serve media from.")
-	folders  = flag.String("folders", "TV,Movies", "Comma-separated list of folders to serve.")
+	folders  = flag.String("folders", "TV,Movies", "Comma-separated list of libraries to serve, each a folder or name:kind:path[:path...] where kind is movies, shows, home, musicvideos or concerts.")
 	port     = flag.Int("port", 8080, "Port to serve from.")
 	password = flag.String("password", "", "Login password.")
 	logdir   = flag.String("logdir", "", "Location to save logs to. If empty, logs to stdout.")
+	index    = flag.String("index", "library.idx", "File to persist the media library index to.")
+
+	progressFile = flag.String("progress", "progress.json", "File to save watch progress to.")
+	queueFile    = flag.String("queue", "queue.json", "File to save the play queue to.")
+	playlistFile = flag.String("playlists", "playlists.json", "File to save playlists to.")
+	binge        = flag.Int("binge", 0, "Number of following episodes to queue when casting an episode, 0 to only play the one.")
+	playerName   = flag.String("player", "vlc", "Player to drive the TV with: vlc, mpv, dlna for a DLNA TV, or fake to try pilot without a TV.")
+	spawnVLC     = flag.Bool("spawn-vlc", false, "Run and supervise cvlc rather than connect to one started separately.")
 
 	httplog *log.Logger
 )
@@ -44,65 +63,49 @@ func logRequests(handler http.Handler) http.Handler {
 	})
 }
 
-var video = map[string]bool{
-	".mp4":  true,
-	".avi":  true,
-	".mpg":  true,
-	".mov":  true,
-	".wmv":  true,
-	".mkv":  true,
-	".m4v":  true,
-	".webm": true,
-	".flv":  true,
-	".3gp":  true,
-}
-
 type server struct {
 	sync.RWMutex
-	Files     []string
-	Player    *vlcctrl.VLC
+	Library   *library.Index
+	Progress  *library.ProgressStore
+	Playlists *playlist.Store
 	Templates map[string]*template.Template
-}
 
-func walker(files *[]string) func(string, os.FileInfo, error) error {
-	return func(path string, info os.FileInfo, _ error) error {
-		if info.IsDir() {
-			inFolder := path == *root
-			for _, folder := range strings.Split(*folders, ",") {
-				if strings.HasPrefix(path, filepath.Join(*root, folder)) {
-					inFolder = true
-					break
-				}
-			}
-			if !inFolder {
-				return filepath.SkipDir
-			}
-		}
-		if video[filepath.Ext(path)] {
-			relPath, err := filepath.Rel(*root, path)
-			if err != nil {
-				log.Printf("error scanning files: %v", err)
-				return err
-			}
-			*files = append(*files, relPath)
-		}
-		return nil
-	}
+	cfg     *Config
+	devices []*device
+	groups  []*group
+	groupID int
+	cvlc    *vlcProcess
+	service *discovery.Service
+	adverts []io.Closer
+	search  *library.Search
+	watcher *library.Watcher
+	// cds is the UPnP content directory last built.
+	cds *contentTree
+	// indexing serializes search index builds, so the last one to finish
+	// has the latest files.
+	indexing sync.Mutex
 }
 
 func (s *server) authenticate(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if *password == "" {
+		password := s.config().Password
+		if password == "" {
 			handler.ServeHTTP(w, r)
 			return
 		}
-		if hasLoginCookie(r) || passwordProtectedDownload(r) {
+		// UPnP is for devices finding and browsing pilot, which can't log in.
+		if hasLoginCookie(r) || passwordProtectedDownload(r, password) || passwordProtectedAPI(r, password) ||
+			tokenProtectedDownload(r) || strings.HasPrefix(r.URL.Path, "/upnp/") {
 			handler.ServeHTTP(w, r)
 			return
 		}
 		if r.URL.Path == "/" {
 			s.LoginHandler(w, r)
 			return
+		} else if strings.HasPrefix(r.URL.Path, apiPrefix) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pilot"`)
+			apiError(w, http.StatusUnauthorized, errors.New("login required"))
+			return
 		} else {
 			http.NotFound(w, r)
 			return
@@ -110,31 +113,164 @@ func (s *server) authenticate(handler http.Handler) http.Handler {
 	})
 }
 
-func (s *server) CurrentlyPlaying() string {
-	playlist, err := s.Player.Playlist()
-	if err != nil {
-		log.Println(err)
+func (s *server) CurrentlyPlaying(d *device) string {
+	status := d.Monitor.Status()
+	if status == nil {
 		return ""
 	}
-	if len(playlist.Children) < 1 {
-		return ""
+	if status.File != "" {
+		return status.File
 	}
-	if len(playlist.Children[0].Children) < 1 {
-		return ""
+	return status.Title
+}
+
+// item returns what a player needs to play filename, relative to the root.
+func (s *server) item(filename string) player.Item {
+	return player.Item{
+		Path:   filename,
+		URL:    s.fileURL(filename),
+		Title:  titleize(filename),
+		Stream: s.streamPath(filename),
+	}
+}
+
+// streamPath returns the path pilot serves filename from. With a password
+// set, it carries a token in its place, for players that can't log in.
+func (s *server) streamPath(filename string) string {
+	v := url.Values{"file": {filename}}
+	if s.config().Password != "" {
+		v.Set("token", downloadToken(filename))
+	}
+	return "/download?" + v.Encode()
+}
+
+// fileURL returns the file:// URL of filename, relative to the root.
+func (s *server) fileURL(filename string) string {
+	root, err := filepath.Abs(s.Library.Root)
+	if err != nil {
+		root = s.Library.Root
+	}
+	u := &url.URL{Scheme: "file", Path: filepath.Join(root, filename)}
+	return u.String()
+}
+
+// PlayOnTV replaces the queue and whatever d is playing with filename,
+// starting start seconds in.
+func (s *server) PlayOnTV(d *device, filename string, start int) error {
+	if !d.Monitor.Online() {
+		return errOffline
+	}
+	log.Printf("playing %s on %s", filepath.Join(s.Library.Root, filename), d.Name)
+	files := []string{filename}
+	if binge := s.config().Binge; binge > 0 {
+		for _, ep := range library.NextEpisodes(s.shows(), filename, binge) {
+			files = append(files, ep.File)
+		}
 	}
-	return playlist.Children[0].Children[0].Name
+	if err := d.Queue.Replace(files...); err != nil {
+		return err
+	}
+	return s.pushQueue(d, true, start)
 }
 
-func (s *server) PlayOnTV(filename string) error {
-	fullpath := filepath.Join(*root, filename)
-	log.Println("playing", fullpath)
-	if err := s.Player.Stop(); err != nil {
+// StopAfterCurrent removes everything queued after what d is playing, so it
+// stops once the current file ends.
+func (s *server) StopAfterCurrent(d *device) error {
+	if err := d.Queue.Truncate(); err != nil {
 		return err
 	}
-	if err := s.Player.EmptyPlaylist(); err != nil {
+	if !d.Monitor.Online() {
+		d.Monitor.markStale()
+		return nil
+	}
+	items, err := s.playlistItems(d)
+	if err != nil {
 		return err
 	}
-	return s.Player.AddStart(fmt.Sprintf("file://%s", url.PathEscape(fullpath)))
+	for i, item := range items {
+		if !item.Current {
+			continue
+		}
+		for _, next := range items[i+1:] {
+			if err := d.player().Remove(next.ID); err != nil {
+				return err
+			}
+		}
+		break
+	}
+	return nil
+}
+
+// kindFolders returns the paths of every library of kind.
+func (s *server) kindFolders(kind string) []string {
+	var folders []string
+	for _, l := range s.libraries() {
+		if l.Kind == kind {
+			folders = append(folders, l.Paths...)
+		}
+	}
+	return folders
+}
+
+// shows returns the shows of every shows library.
+func (s *server) shows() []*library.Show {
+	return library.Shows(s.kindFolders(library.ShowsKind), s.Library.List())
+}
+
+// LibraryContents is what a library holds, parsed according to its kind.
+type LibraryContents struct {
+	*library.Library
+	Movies []*library.Movie `json:"movies,omitempty"`
+	Shows  []*library.Show  `json:"shows,omitempty"`
+	Groups []*library.Group `json:"groups,omitempty"`
+}
+
+func (s *server) libraryContents(l *library.Library) *LibraryContents {
+	c := &LibraryContents{Library: l}
+	files := l.Files(s.Library.List())
+	switch l.Kind {
+	case library.MoviesKind, library.ConcertsKind:
+		c.Movies = library.Movies(files)
+	case library.ShowsKind:
+		c.Shows = library.Shows(l.Paths, files)
+	default:
+		c.Groups = l.Groups(files)
+	}
+	return c
+}
+
+// findLibrary returns the library called name, ignoring case, or nil.
+func (s *server) findLibrary(name string) *library.Library {
+	for _, l := range s.libraries() {
+		if strings.EqualFold(l.Name, name) {
+			return l
+		}
+	}
+	return nil
+}
+
+// waitStarted waits for the player of d to open the file it was just given,
+// since VLC ignores seeks until it knows the length.
+func (s *server) waitStarted(d *device) error {
+	for i := 0; i < 20; i++ {
+		status, err := d.player().Status()
+		if err != nil {
+			return err
+		}
+		if status.Length > 0 {
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return errors.New("timed out waiting for the file to start")
+}
+
+// seekWhenStarted seeks to start once the player of d opened its file.
+func (s *server) seekWhenStarted(d *device, start int) error {
+	if err := s.waitStarted(d); err != nil {
+		return fmt.Errorf("error seeking to %d: %v", start, err)
+	}
+	return d.player().Seek(start, false)
 }
 
 func (s *server) DownloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -143,7 +279,7 @@ func (s *server) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "error decoding query: %v", err)
 		return
 	}
-	f, err := os.Open(filepath.Join(*root, file))
+	f, err := os.Open(filepath.Join(s.Library.Root, file))
 	if err != nil {
 		if os.IsNotExist(err) {
 			w.WriteHeader(http.StatusNotFound)
@@ -161,12 +297,19 @@ func (s *server) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Add(
 		"Content-Disposition", "attachment;filename="+filepath.Base(file))
+	w.Header().Set("Content-Type", upnp.MimeType(file))
+	if r.Header.Get("getcontentFeatures.dlna.org") != "" {
+		// DLNA TVs ask whether they can seek in the file, some minding the
+		// case of the headers.
+		w.Header()["contentFeatures.dlna.org"] = []string{upnp.ContentFeatures}
+		w.Header()["transferMode.dlna.org"] = []string{"Streaming"}
+	}
 	http.ServeContent(w, r, file, fi.ModTime(), f)
 }
 
 func (s *server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	pass := r.FormValue("password")
-	if pass == *password {
+	if pass == s.config().Password {
 		encoded, err := bakery.Encode("login", &LoginCookie{LoginTime: time.Now()})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -198,11 +341,35 @@ func (s *server) StaticHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "pure-min.css")
 }
 
+func (s *server) ModifiedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &struct {
+		Modified int64 `json:"modified"`
+	}{
+		Modified: unixMillis(s.Library.LastModified()),
+	})
+}
+
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 type IndexTemplateParams struct {
-	Playing string
-	Movies  []string
-	Shows   map[string]map[string][]string
-	Filter  string
+	*LibraryContents
+	Modified  int64
+	Playing   string
+	UpNext    []*library.Episode
+	Filter    string
+	Playlists []*playlist.Playlist
+}
+
+// QueueButtons is what the queue template needs for a file: the file, and
+// the playlists it can be added to, listed once per page.
+type QueueButtons struct {
+	File      string
+	Playlists []*playlist.Playlist
 }
 
 var re = regexp.MustCompile("[^a-z0-9]+")
@@ -215,51 +382,45 @@ func slugify(s ...string) string {
 	return strings.Join(slugs, "-")
 }
 
+// formatTime formats seconds like 42:10 or 1:02:03.
+func formatTime(seconds int) string {
+	h, m, sec := seconds/3600, seconds/60%60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
 func titleize(s string) string {
 	title := filepath.Base(s)
 	ext := filepath.Ext(title)
 	return strings.TrimSuffix(title, ext)
 }
 
-func (p *IndexTemplateParams) InsertShow(show string, season string, episode string) {
-	if p.Shows[show] == nil {
-		p.Shows[show] = make(map[string][]string)
-	}
-	p.Shows[show][season] = append(p.Shows[show][season], episode)
-}
-
 func (s *server) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	reload := r.URL.Query()["reload"]
 	if len(reload) > 0 {
 		s.reload()
 	}
 	params := &IndexTemplateParams{
-		Playing: s.CurrentlyPlaying(),
-		Shows:   make(map[string]map[string][]string),
-		Filter:  "Movies",
+		Modified:  unixMillis(s.Library.LastModified()),
+		Playing:   s.CurrentlyPlaying(s.currentDevice(r)),
+		Filter:    s.libraries()[0].Name,
+		Playlists: s.Playlists.List(),
 	}
 	filters, ok := r.URL.Query()["filter"]
 	if ok && len(filters) > 0 {
 		params.Filter = filters[0]
 	}
-	for _, f := range s.Files {
-		if !strings.HasPrefix(f, params.Filter) {
-			continue
-		}
-		if strings.HasPrefix(f, "Movies") {
-			params.Movies = append(params.Movies, f)
-		} else if strings.HasPrefix(f, "TV") {
-			path := strings.Split(f, "/")
-			if len(path) == 3 {
-				_, show, episode := path[0], path[1], path[2]
-				params.InsertShow(show, "", episode)
-			} else if len(path) == 4 {
-				_, show, season, episode := path[0], path[1], path[2], path[3]
-				params.InsertShow(show, season, episode)
-			} else {
-				panic(fmt.Sprintf("Failed to get episode information for %s", f))
-			}
-		}
+	l := s.findLibrary(params.Filter)
+	if l == nil {
+		http.NotFound(w, r)
+		return
+	}
+	params.Filter = l.Name
+	params.LibraryContents = s.libraryContents(l)
+	if l.Kind == library.ShowsKind {
+		params.UpNext = s.Progress.UpNext(params.Shows)
 	}
 	if err := s.Templates["index.html"].Execute(w, params); err != nil {
 		log.Println(err)
@@ -267,21 +428,43 @@ func (s *server) IndexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type PlayTemplateParams struct {
-	File  string
-	Title string
+	File   string
+	Title  string
+	Start  int
+	Resume int
+	Next   string
 }
 
 func (s *server) PlayHandler(w http.ResponseWriter, r *http.Request) {
 	file, ok := r.URL.Query()["file"]
+	var next string
+	if id := r.FormValue("playlist"); id != "" {
+		// Playlists play one entry after the other, i is the one to play now.
+		n, _ := strconv.Atoi(id)
+		i, _ := strconv.Atoi(r.FormValue("i"))
+		pl, err := s.Playlists.Get(n)
+		if err != nil || i < 0 || i >= len(pl.Files) {
+			http.NotFound(w, r)
+			return
+		}
+		file, ok = []string{pl.Files[i]}, true
+		if i+1 < len(pl.Files) {
+			next = fmt.Sprintf("/play?playlist=%d&i=%d", n, i+1)
+		}
+	}
 	if !ok || len(file) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	title := filepath.Base(file[0])
 	ext := filepath.Ext(title)
+	start, _ := strconv.Atoi(r.FormValue("t"))
 	params := &PlayTemplateParams{
-		File:  file[0],
-		Title: strings.TrimSuffix(title, ext),
+		File:   file[0],
+		Title:  strings.TrimSuffix(title, ext),
+		Start:  start,
+		Resume: s.Progress.Get(file[0]).Resume(),
+		Next:   next,
 	}
 	if err := s.Templates["play.html"].Execute(w, params); err != nil {
 		log.Println(err)
@@ -290,7 +473,15 @@ func (s *server) PlayHandler(w http.ResponseWriter, r *http.Request) {
 
 type CastTemplateParams struct {
 	Playing string
-	UISrc   string
+	File    string
+	Start   int
+	Offline bool
+	Device  string
+	Devices []DeviceState
+	// Choose is set when there are several devices to cast File to and
+	// none was picked yet, InSync when it can play on all of them at once.
+	Choose bool
+	InSync bool
 }
 
 func (s *server) CastHandler(w http.ResponseWriter, r *http.Request) {
@@ -299,19 +490,68 @@ func (s *server) CastHandler(w http.ResponseWriter, r *http.Request) {
 	if len(files) > 0 {
 		file = files[0]
 	}
-	if file != "" && file != s.CurrentlyPlaying() {
-		s.PlayOnTV(file)
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	d, err := s.requestDevice(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	picked := r.FormValue("device") != ""
+	if picked {
+		pickDevice(w, d)
+	}
+	inSync := r.FormValue("sync") == "true"
+	choose := file != "" && !picked && !inSync && len(s.deviceList()) > 1
+	if file != "" && !choose && d.Monitor.Online() && (file != s.CurrentlyPlaying(d) || start > 0) {
+		if inSync {
+			// The device of this remote leads.
+			devices := []*device{d}
+			for _, other := range s.onlineDevices() {
+				if other != d {
+					devices = append(devices, other)
+				}
+			}
+			_, err = s.PlayInSync(devices, file, start)
+		} else {
+			err = s.PlayOnTV(d, file, start)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		if err := s.refresh(d); err != nil {
+			log.Println(err)
+		}
 	}
-	publicAddr := GetOutboundIP()
 	params := &CastTemplateParams{
-		Playing: s.CurrentlyPlaying(),
-		UISrc:   fmt.Sprintf("http://%s:%d", publicAddr.String(), *port+1),
+		Playing: s.CurrentlyPlaying(d),
+		File:    file,
+		Start:   start,
+		Offline: !d.Monitor.Online(),
+		Device:  d.Name,
+		Devices: s.deviceStates(),
+		Choose:  choose,
+		InSync:  len(s.onlineDevices()) > 1,
 	}
 	if err := s.Templates["cast.html"].Execute(w, params); err != nil {
 		log.Println(err)
 	}
 }
 
+type PlaylistsTemplateParams struct {
+	Playing   string
+	Playlists []*playlist.Playlist
+}
+
+func (s *server) PlaylistsHandler(w http.ResponseWriter, r *http.Request) {
+	params := &PlaylistsTemplateParams{
+		Playing:   s.CurrentlyPlaying(s.currentDevice(r)),
+		Playlists: s.Playlists.List(),
+	}
+	if err := s.Templates["playlists.html"].Execute(w, params); err != nil {
+		log.Println(err)
+	}
+}
+
 func GetOutboundIP() net.IP {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -325,87 +565,201 @@ func GetOutboundIP() net.IP {
 }
 
 func (s *server) reload() {
-	var files []string
-	filepath.Walk(*root, walker(&files))
-	s.Lock()
-	s.Files = files
-	s.Unlock()
+	if err := s.Library.Scan(); err != nil {
+		log.Printf("error scanning files: %v", err)
+		return
+	}
+	if err := s.Library.Save(); err != nil {
+		log.Printf("error saving library index: %v", err)
+	}
+	log.Printf("found %d files", s.Library.Len())
+	go s.indexSearch()
 }
 
-func setupCEC(p *vlcctrl.VLC) {
-	conn, err := cec.Open("", "pilot")
-	if err != nil {
-		log.Fatal(err)
+// watch starts watching the library folders for changes, replacing the
+// previous watcher if there was one.
+func (s *server) watch() {
+	s.Lock()
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.watcher = nil
 	}
-	conn.On(cec.Pause, func() {
-		p.Pause()
-	})
-	conn.On(cec.Play, func() {
-		p.Play()
+	s.Unlock()
+	watcher, err := s.Library.Watch(2*time.Second, func() {
+		if err := s.Library.Save(); err != nil {
+			log.Printf("error saving library index: %v", err)
+		}
+		log.Printf("library changed, found %d files", s.Library.Len())
+		go s.indexSearch()
 	})
-	conn.On(cec.Stop, func() {
-		if err := p.EmptyPlaylist(); err != nil {
+	if err != nil {
+		log.Printf("error watching for new files, use reload instead: %v", err)
+		return
+	}
+	s.Lock()
+	s.watcher = watcher
+	s.Unlock()
+}
+
+// setupCEC opens the CEC adapter of every device that has one, so the keys of
+// each TV remote control the device on that TV.
+func (s *server) setupCEC() {
+	for _, cfg := range s.config().Devices {
+		if !cfg.CEC.Enabled {
+			continue
+		}
+		conn, err := cec.Open(cfg.CEC.Adapter, cfg.CEC.Name)
+		if err != nil {
 			log.Fatal(err)
 		}
-		p.Stop()
-	})
+		name := cfg.Name
+		for event, action := range map[cec.EventType]func(d *device) error{
+			cec.Pause: func(d *device) error { return d.player().Toggle() },
+			cec.Play:  func(d *device) error { return d.player().Play() },
+			cec.Stop:  s.ClearTV,
+			cec.Red:   s.StopAfterCurrent,
+		} {
+			action := action
+			conn.On(event, func() {
+				// Look the device up each time, it may have been reloaded.
+				d := s.findDevice(name)
+				if d == nil {
+					return
+				}
+				if err := action(d); err != nil {
+					log.Printf("%s: %v", name, err)
+				}
+			})
+		}
+	}
 }
 
 func main() {
 	flag.Parse()
 
-	logrus.SetLevel(logrus.DebugLevel)
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	level, _ := logrus.ParseLevel(cfg.Log.Level)
+	logrus.SetLevel(level)
 	log.SetFlags(log.Flags() | log.Lshortfile)
-	if *logdir != "" {
+	if cfg.Log.Dir != "" {
 		httplog = log.New(&lumberjack.Logger{
-			Filename: filepath.Join(*logdir, "httprequests.log"),
+			Filename: filepath.Join(cfg.Log.Dir, "httprequests.log"),
 			MaxSize:  50, // megabytes
 			MaxAge:   30, //days
 		}, "", log.Flags())
 		log.SetOutput(&lumberjack.Logger{
-			Filename: filepath.Join(*logdir, "output.log"),
+			Filename: filepath.Join(cfg.Log.Dir, "output.log"),
 			MaxSize:  50, // megabytes MaxAge:   30, //days
 		})
 	} else {
 		httplog = log.New(os.Stderr, "", log.Flags())
 	}
 
-	player, err := vlcctrl.NewVLC("127.0.0.1", 8081, "raspberry")
+	lib, err := library.Open(cfg.Files.Index, cfg.Root, library.Folders(cfg.Libraries))
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal(fmt.Errorf("error loading library index %s: %v", cfg.Files.Index, err))
+	}
+	progress, err := library.OpenProgress(cfg.Files.Progress)
+	if err != nil {
+		log.Fatal(fmt.Errorf("error loading watch progress %s: %v", cfg.Files.Progress, err))
 	}
-	if _, err = player.GetStatus(); err != nil {
-		log.Fatal(fmt.Errorf("error, expected VLC on port 8081, got: %s", err))
+	playlists, err := playlist.Open(cfg.Files.Playlists)
+	if err != nil {
+		log.Fatal(fmt.Errorf("error loading playlists %s: %v", cfg.Files.Playlists, err))
 	}
 
-	setupCEC(&player)
+	var cvlc *vlcProcess
+	if spawned := cfg.spawned(); spawned != nil {
+		cvlc, err = startVLC(spawned.VLC)
+		if err != nil {
+			log.Fatal(fmt.Errorf("error starting %s: %v", spawned.VLC.Command, err))
+		}
+	}
 
 	s := &server{
+		Library:   lib,
 		Templates: make(map[string]*template.Template),
-		Player:    &player,
+		Progress:  progress,
+		Playlists: playlists,
+		cfg:       cfg,
+		cvlc:      cvlc,
+	}
+	go s.stopOnExit()
+	for i, dc := range cfg.Devices {
+		filename := deviceQueueFile(cfg.Files.Queue, dc.Name, i == 0)
+		d, err := s.newDevice(dc, filename)
+		if err != nil {
+			log.Fatal(fmt.Errorf("error loading play queue %s: %v", filename, err))
+		}
+		s.devices = append(s.devices, d)
 	}
-	for _, t := range []string{"index.html", "play.html", "login.html", "cast.html"} {
+	s.setupCEC()
+	for _, t := range []string{"index.html", "play.html", "login.html", "cast.html", "playlists.html", "search.html"} {
 		s.Templates[t] = template.Must(template.New(t).Funcs(template.FuncMap{
 			"slugify":    slugify,
 			"titleize":   titleize,
 			"trimPrefix": strings.TrimPrefix,
+			"formatTime": formatTime,
+			"resume": func(file string) int {
+				return s.Progress.Get(file).Resume()
+			},
+			"watched": s.Progress.Watched,
+			"queueButtons": func(file string, playlists []*playlist.Playlist) *QueueButtons {
+				return &QueueButtons{File: file, Playlists: playlists}
+			},
+			"libraries": s.libraries,
+			"add": func(a, b int) int {
+				return a + b
+			},
 		}).ParseFiles(t))
 	}
 
-	log.Println("pilot is up, looking for files to serve...")
-	s.Lock()
-	filepath.Walk(*root, walker(&s.Files))
-	s.Unlock()
-	log.Printf("found %d files", len(s.Files))
+	log.Printf("pilot is up with %d indexed files, looking for changes...", lib.Len())
+	go func() {
+		s.reload()
+		s.watch()
+	}()
+	for _, d := range s.devices {
+		go s.poll(d)
+	}
+	if *configFile != "" {
+		go s.reloadOnHangup(*configFile)
+	}
+	s.advertise()
 
+	controls := rpc.NewServer()
+	controls.RegisterCodec(json.NewCodec(), "application/json")
+	if err := controls.RegisterService(&Controls{s}, ""); err != nil {
+		log.Fatal(err)
+	}
+	http.Handle("/controls", controls)
+	http.HandleFunc("/files.json", s.FilesHandler)
+	http.Handle("/src/", http.FileServer(http.Dir("app")))
+	http.Handle("/bower_components/", http.FileServer(http.Dir("app")))
+	http.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "app/index.html")
+	})
+	http.HandleFunc(apiPrefix, s.APIHandler)
 	http.HandleFunc("/download", s.DownloadHandler)
+	http.HandleFunc("/modified", s.ModifiedHandler)
+	http.HandleFunc("/events", s.EventsHandler)
 	http.HandleFunc("/favicon.ico", s.FaviconHandler)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 	http.HandleFunc("/play", s.PlayHandler)
 	http.HandleFunc("/cast", s.CastHandler)
+	http.HandleFunc("/playlists", s.PlaylistsHandler)
+	http.HandleFunc("/search", s.SearchHandler)
+	http.HandleFunc(descriptionPath, s.DescriptionHandler)
+	http.HandleFunc("/upnp/", s.MediaServerHandler)
 	http.HandleFunc("/", s.IndexHandler)
 
-	log.Fatal(http.ListenAndServe(
-		fmt.Sprintf(":%d", *port),
-		s.authenticate(logRequests(http.DefaultServeMux))))
+	err = http.ListenAndServe(
+		fmt.Sprintf(":%d", cfg.Port),
+		s.authenticate(logRequests(http.DefaultServeMux)))
+	s.shutdown()
+	log.Fatal(err)
 }
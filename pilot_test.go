@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etherealmachine/pilot/library"
+	"github.com/etherealmachine/pilot/playlist"
+)
+
+// testFiles is the library of the test server.
+var testFiles = []string{
+	"Movies/Alien (1979).mkv",
+	"Movies/Heat (1995).mkv",
+	"TV/Show/Season 1/Show - 101 - Pilot.mkv",
+	"TV/Show/Season 1/Show - 102 - Second.mkv",
+	"TV/Show/Season 1/Show - 103 - Third.mkv",
+}
+
+// newTestServer returns a server for testFiles, with a fake player for every
+// device named, which are online.
+func newTestServer(t *testing.T, devices ...string) *server {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "media")
+	for _, file := range testFiles {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	libraries, err := library.ParseLibraries("TV,Movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Root:      root,
+		Port:      8080,
+		Libraries: libraries,
+		Player:    "fake",
+		Files: FilesConfig{
+			Index:     filepath.Join(dir, "library.idx"),
+			Progress:  filepath.Join(dir, "progress.json"),
+			Queue:     filepath.Join(dir, "queue.json"),
+			Playlists: filepath.Join(dir, "playlists.json"),
+		},
+	}
+	lib, err := library.Open(cfg.Files.Index, root, library.Folders(libraries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	progress, err := library.OpenProgress(cfg.Files.Progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlists, err := playlist.Open(cfg.Files.Playlists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{Library: lib, Progress: progress, Playlists: playlists, cfg: cfg}
+	for i, name := range devices {
+		dc := &DeviceConfig{Name: name, Player: "fake"}
+		cfg.Devices = append(cfg.Devices, dc)
+		d, err := s.newDevice(dc, deviceQueueFile(cfg.Files.Queue, name, i == 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first check finds the player online, as polling would.
+		if err := s.refresh(d); err != nil {
+			t.Fatal(err)
+		}
+		s.devices = append(s.devices, d)
+	}
+	return s
+}
+
+// playlistFiles returns the files in the playlist of the player of d, and the
+// one playing.
+func playlistFiles(t *testing.T, s *server, d *device) (files []string, current string) {
+	t.Helper()
+	items, err := s.playlistItems(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		files = append(files, item.File)
+		if item.Current {
+			current = item.File
+		}
+	}
+	return files, current
+}
+
+func TestPlayOnTV(t *testing.T) {
+	s := newTestServer(t, "TV")
+	d := s.devices[0]
+	if err := s.PlayOnTV(d, "Movies/Heat (1995).mkv", 90); err != nil {
+		t.Fatal(err)
+	}
+	status, err := d.player().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "playing" || status.Title != "Heat (1995)" || status.Time < 90 {
+		t.Errorf("status %+v, want Heat playing from 90s", status)
+	}
+	if files, current := d.Queue.List(); !reflect.DeepEqual(files, []string{"Movies/Heat (1995).mkv"}) || current != 0 {
+		t.Errorf("queue %v at %d", files, current)
+	}
+
+	// Casting an episode while bingeing queues the next ones after it.
+	s.cfg.Binge = 2
+	if err := s.PlayOnTV(d, "TV/Show/Season 1/Show - 101 - Pilot.mkv", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"TV/Show/Season 1/Show - 101 - Pilot.mkv",
+		"TV/Show/Season 1/Show - 102 - Second.mkv",
+		"TV/Show/Season 1/Show - 103 - Third.mkv",
+	}
+	if files, _ := d.Queue.List(); !reflect.DeepEqual(files, want) {
+		t.Errorf("queue %v, want %v", files, want)
+	}
+	if files, current := playlistFiles(t, s, d); !reflect.DeepEqual(files, want) || current != want[0] {
+		t.Errorf("playlist %v playing %q, want %v playing the first", files, current, want)
+	}
+
+	d.Monitor.offline()
+	if err := s.PlayOnTV(d, "Movies/Heat (1995).mkv", 0); err != errOffline {
+		t.Errorf("played on an offline TV: %v", err)
+	}
+}
@@ -0,0 +1,513 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etherealmachine/pilot/discovery"
+	"github.com/etherealmachine/pilot/upnp"
+)
+
+// DLNASearch is how long to wait for renderers to answer a search.
+var DLNASearch = 2 * time.Second
+
+// dlnaEndSlack is how close to its end a file must have got for the renderer
+// stopping to mean it ended, rather than that someone stopped it.
+const dlnaEndSlack = 5
+
+var (
+	errDLNAUnsupported = errors.New("not supported by dlna renderers")
+	errDLNALost        = errors.New("lost the dlna renderer")
+)
+
+// DLNA is a Player for a UPnP MediaRenderer, such as a smart TV, driven over
+// AVTransport and RenderingControl. Renderers play one file at a time, so the
+// playlist is kept here, and the next file started when the renderer stops
+// at the end of one. Files are streamed from pilot. Build using NewDLNA().
+type DLNA struct {
+	renderer string
+	port     int
+
+	mu sync.Mutex
+	// avt and rc are the control URLs of the renderer, empty until it's
+	// found, and host its address.
+	avt, rc string
+	host    string
+	entries []dlnaEntry
+	nextID  int
+	current int
+	// playing is set while the current entry should be playing, and time and
+	// length are how far into it the renderer last was, to tell when it ends.
+	playing      bool
+	time, length int
+}
+
+type dlnaEntry struct {
+	Entry
+	stream string
+}
+
+// NewDLNA returns a Player for renderer, the friendly name or UDN of a
+// MediaRenderer found over SSDP, or the URL of its description, or the first
+// one found if empty. Pilot serves the files it plays on port.
+func NewDLNA(renderer string, port int) *DLNA {
+	return &DLNA{renderer: renderer, port: port, nextID: 1, current: -1}
+}
+
+// lock locks the player, once the renderer was found. It's searched for
+// without holding the lock, as that takes a while.
+func (p *DLNA) lock() error {
+	p.mu.Lock()
+	if p.avt != "" {
+		return nil
+	}
+	p.mu.Unlock()
+	avt, rc, host, err := p.find()
+	p.mu.Lock()
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if p.avt == "" {
+		p.avt, p.rc, p.host = avt, rc, host
+	}
+	return nil
+}
+
+// find locates the renderer, returning the control URLs of its services and
+// its address.
+func (p *DLNA) find() (avt, rc, host string, err error) {
+	var locations []string
+	if strings.HasPrefix(p.renderer, "http://") || strings.HasPrefix(p.renderer, "https://") {
+		locations = []string{p.renderer}
+	} else {
+		results, err := discovery.Search(upnp.MediaRenderer, DLNASearch)
+		if err != nil {
+			return "", "", "", err
+		}
+		for _, r := range results {
+			locations = append(locations, r.Location)
+		}
+	}
+	for _, location := range locations {
+		root, err := upnp.ReadDescription(location)
+		if err != nil {
+			continue
+		}
+		d := root.Device.Find(upnp.MediaRenderer)
+		if d == nil || location != p.renderer && !p.matches(d) {
+			continue
+		}
+		avt := root.ControlURL(d, upnp.AVTransport)
+		u, err := url.Parse(avt)
+		if avt == "" || err != nil {
+			continue
+		}
+		return avt, root.ControlURL(d, upnp.RenderingControl), u.Hostname(), nil
+	}
+	if p.renderer == "" {
+		return "", "", "", errors.New("no dlna renderer found")
+	}
+	return "", "", "", fmt.Errorf("dlna renderer %q not found", p.renderer)
+}
+
+// matches reports whether d is the renderer the player was asked for.
+func (p *DLNA) matches(d *upnp.Device) bool {
+	return p.renderer == "" ||
+		strings.EqualFold(d.FriendlyName, p.renderer) ||
+		strings.EqualFold(strings.TrimPrefix(d.UDN, "uuid:"), strings.TrimPrefix(p.renderer, "uuid:"))
+}
+
+// call calls action of the AVTransport or RenderingControl of the renderer,
+// for its only instance. The player must be locked with lock().
+func (p *DLNA) call(service, action string, args ...upnp.Arg) (map[string]string, error) {
+	if p.avt == "" {
+		// An earlier call lost it, the next lock looks for it again.
+		return nil, errDLNALost
+	}
+	controlURL := p.avt
+	if service == upnp.RenderingControl {
+		if p.rc == "" {
+			return nil, errDLNAUnsupported
+		}
+		controlURL = p.rc
+	}
+	args = append([]upnp.Arg{{Name: "InstanceID", Value: "0"}}, args...)
+	values, err := upnp.Call(controlURL, service, action, args...)
+	var upnpErr *upnp.Error
+	if err != nil && !errors.As(err, &upnpErr) {
+		// The renderer may have moved, so it's looked for again next time.
+		p.avt = ""
+	}
+	return values, err
+}
+
+// streamURL returns where the renderer fetches e from pilot: pilot's address
+// as the renderer sees it.
+func (p *DLNA) streamURL(e dlnaEntry) string {
+	if e.stream == "" {
+		return e.URL
+	}
+	host := "127.0.0.1"
+	if conn, err := net.Dial("udp", net.JoinHostPort(p.host, "9")); err == nil {
+		host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(p.port)) + e.stream
+}
+
+func (p *DLNA) transportState() (string, error) {
+	info, err := p.call(upnp.AVTransport, "GetTransportInfo")
+	if err != nil {
+		return "", err
+	}
+	return info["CurrentTransportState"], nil
+}
+
+// stop stops the renderer. Renderers refuse to stop with nothing to stop,
+// which is fine.
+func (p *DLNA) stop() error {
+	p.playing = false
+	_, err := p.call(upnp.AVTransport, "Stop")
+	var upnpErr *upnp.Error
+	if errors.As(err, &upnpErr) {
+		return nil
+	}
+	return err
+}
+
+// start plays entry i from the start.
+func (p *DLNA) start(i int) error {
+	e := p.entries[i]
+	uri := p.streamURL(e)
+	didl := &upnp.DIDL{Items: []upnp.Item{{
+		ID:       strconv.Itoa(e.ID),
+		ParentID: "-1",
+		Title:    e.Title,
+		Class:    upnp.VideoClass,
+		Res:      []upnp.Res{{ProtocolInfo: upnp.ProtocolInfo(e.URL), URL: uri}},
+	}}}
+	meta, err := didl.Marshal()
+	if err != nil {
+		return err
+	}
+	// Some renderers won't take a new file while playing one.
+	if err := p.stop(); err != nil {
+		return err
+	}
+	if _, err := p.call(upnp.AVTransport, "SetAVTransportURI",
+		upnp.Arg{Name: "CurrentURI", Value: uri},
+		upnp.Arg{Name: "CurrentURIMetaData", Value: meta}); err != nil {
+		return err
+	}
+	if _, err := p.call(upnp.AVTransport, "Play", upnp.Arg{Name: "Speed", Value: "1"}); err != nil {
+		return err
+	}
+	p.current, p.playing, p.time, p.length = i, true, 0, 0
+	return nil
+}
+
+func (p *DLNA) index(id int) int {
+	for i, e := range p.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *DLNA) Status() (*Status, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mu.Unlock()
+	state, err := p.transportState()
+	if err != nil {
+		return nil, err
+	}
+	pos, err := p.call(upnp.AVTransport, "GetPositionInfo")
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{
+		State:          "stopped",
+		Time:           upnp.ParseTime(pos["RelTime"]),
+		Length:         upnp.ParseTime(pos["TrackDuration"]),
+		Rate:           1,
+		AudioTracks:    []Track{},
+		SubtitleTracks: []Track{},
+		VideoTracks:    []Track{},
+		Current:        -1,
+	}
+	switch state {
+	case "PLAYING", "TRANSITIONING":
+		s.State = "playing"
+	case "PAUSED_PLAYBACK", "PAUSED_RECORDING":
+		s.State = "paused"
+	}
+	if s.State == "stopped" && p.playing {
+		p.playing = false
+		if p.length > 0 && p.time >= p.length-dlnaEndSlack && p.current+1 < len(p.entries) {
+			if err := p.start(p.current + 1); err != nil {
+				return nil, err
+			}
+			s.State, s.Time, s.Length = "playing", 0, 0
+		}
+	}
+	if s.State == "playing" {
+		p.playing, p.time, p.length = true, s.Time, s.Length
+	}
+	if s.Length > 0 {
+		s.Position = float64(s.Time) / float64(s.Length)
+	}
+	// Renderers play on without telling their volume, which is then unknown.
+	s.Volume = -1
+	if p.rc != "" {
+		volume, err := p.call(upnp.RenderingControl, "GetVolume", upnp.Arg{Name: "Channel", Value: "Master"})
+		if v, convErr := strconv.Atoi(volume["CurrentVolume"]); err == nil && convErr == nil {
+			s.Volume = v * 256 / 100
+		}
+	}
+	if p.current >= 0 {
+		e := p.entries[p.current]
+		s.Title, s.Filename, s.Current = e.Title, path.Base(e.URL), e.ID
+	}
+	return s, nil
+}
+
+func (p *DLNA) Playlist() ([]Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries := make([]Entry, len(p.entries))
+	for i, e := range p.entries {
+		e.Current = i == p.current
+		entries[i] = e.Entry
+	}
+	return entries, nil
+}
+
+func (p *DLNA) Load(item Item, play bool) error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	p.entries = append(p.entries, dlnaEntry{
+		Entry:  Entry{ID: p.nextID, URL: item.URL, Title: item.Title},
+		stream: item.Stream,
+	})
+	p.nextID++
+	if play {
+		return p.start(len(p.entries) - 1)
+	}
+	return nil
+}
+
+func (p *DLNA) Remove(id int) error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	i := p.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	p.entries = append(p.entries[:i], p.entries[i+1:]...)
+	switch {
+	case i == p.current:
+		p.current = -1
+		return p.stop()
+	case i < p.current:
+		p.current--
+	}
+	return nil
+}
+
+func (p *DLNA) Clear() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	p.entries, p.current = nil, -1
+	return p.stop()
+}
+
+func (p *DLNA) PlayEntry(id int) error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	i := p.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	return p.start(i)
+}
+
+func (p *DLNA) Play() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	return p.play()
+}
+
+func (p *DLNA) play() error {
+	state, err := p.transportState()
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(state, "PAUSED"):
+		p.playing = true
+		_, err := p.call(upnp.AVTransport, "Play", upnp.Arg{Name: "Speed", Value: "1"})
+		return err
+	case state == "PLAYING" || state == "TRANSITIONING":
+		return nil
+	case p.current >= 0:
+		return p.start(p.current)
+	case len(p.entries) > 0:
+		return p.start(0)
+	}
+	return nil
+}
+
+func (p *DLNA) Pause() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	return p.pause()
+}
+
+func (p *DLNA) pause() error {
+	state, err := p.transportState()
+	if err != nil || state != "PLAYING" {
+		return err
+	}
+	_, err = p.call(upnp.AVTransport, "Pause")
+	return err
+}
+
+func (p *DLNA) Toggle() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	state, err := p.transportState()
+	if err != nil {
+		return err
+	}
+	if state == "PLAYING" {
+		return p.pause()
+	}
+	return p.play()
+}
+
+func (p *DLNA) Stop() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	return p.stop()
+}
+
+func (p *DLNA) Next() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if p.current+1 < len(p.entries) {
+		return p.start(p.current + 1)
+	}
+	return nil
+}
+
+func (p *DLNA) Previous() error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if p.current > 0 {
+		return p.start(p.current - 1)
+	}
+	return nil
+}
+
+func (p *DLNA) Seek(seconds int, relative bool) error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if relative {
+		pos, err := p.call(upnp.AVTransport, "GetPositionInfo")
+		if err != nil {
+			return err
+		}
+		seconds += upnp.ParseTime(pos["RelTime"])
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+	_, err := p.call(upnp.AVTransport, "Seek",
+		upnp.Arg{Name: "Unit", Value: "REL_TIME"},
+		upnp.Arg{Name: "Target", Value: upnp.FormatTime(seconds)})
+	return err
+}
+
+// SetVolume sets the volume of the TV, from 0 to 100, where 256 is 100.
+func (p *DLNA) SetVolume(volume int, relative bool) error {
+	if err := p.lock(); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	volume = volume * 100 / 256
+	if relative {
+		current, err := p.call(upnp.RenderingControl, "GetVolume", upnp.Arg{Name: "Channel", Value: "Master"})
+		if err != nil {
+			return err
+		}
+		v, _ := strconv.Atoi(current["CurrentVolume"])
+		volume += v
+	}
+	if volume < 0 {
+		volume = 0
+	}
+	if volume > 100 {
+		volume = 100
+	}
+	_, err := p.call(upnp.RenderingControl, "SetVolume",
+		upnp.Arg{Name: "Channel", Value: "Master"},
+		upnp.Arg{Name: "DesiredVolume", Value: strconv.Itoa(volume)})
+	return err
+}
+
+// SetRate only takes normal speed, as few renderers play at any other.
+func (p *DLNA) SetRate(rate float64) error {
+	if rate != 1 {
+		return errDLNAUnsupported
+	}
+	return nil
+}
+
+func (p *DLNA) SelectAudioTrack(id int) error {
+	return errDLNAUnsupported
+}
+
+func (p *DLNA) SelectSubtitleTrack(id int) error {
+	return errDLNAUnsupported
+}
+
+func (p *DLNA) SelectVideoTrack(id int) error {
+	return errDLNAUnsupported
+}
+
+func (p *DLNA) SelectChapter(id int) error {
+	return errDLNAUnsupported
+}
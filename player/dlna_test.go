@@ -0,0 +1,356 @@
+package player
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etherealmachine/pilot/upnp"
+)
+
+// fakeRenderer is a UPnP MediaRenderer with an AVTransport and a
+// RenderingControl, whose playback only moves when a test moves it.
+type fakeRenderer struct {
+	mu       sync.Mutex
+	state    string
+	uri      string
+	meta     string
+	time     int
+	duration int
+	volume   int
+	calls    []string
+	// mute makes the renderer fail to tell its volume.
+	mute bool
+	// hold, if set, is sent to when the description is asked for, and again
+	// before it's given.
+	hold chan struct{}
+}
+
+func newFakeRenderer(t *testing.T) (*fakeRenderer, *httptest.Server) {
+	f := &fakeRenderer{state: "NO_MEDIA_PRESENT", volume: 30}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/description.xml", func(w http.ResponseWriter, r *http.Request) {
+		if f.hold != nil {
+			f.hold <- struct{}{}
+			f.hold <- struct{}{}
+		}
+		desc := upnp.Root{
+			SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
+			Device: upnp.Device{
+				DeviceType:   upnp.MediaRenderer,
+				FriendlyName: "Fake TV",
+				UDN:          "uuid:fake-tv",
+				ServiceList: &upnp.ServiceList{Services: []upnp.Service{
+					{ServiceType: upnp.AVTransport, ControlURL: "/avt"},
+					{ServiceType: upnp.RenderingControl, ControlURL: "/rc"},
+				}},
+			},
+		}
+		if err := xml.NewEncoder(w).Encode(desc); err != nil {
+			t.Error(err)
+		}
+	})
+	mux.HandleFunc("/avt", f.control)
+	mux.HandleFunc("/rc", f.control)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// transitionNotAvailable is what renderers fail actions with that make no
+// sense in their state.
+var transitionNotAvailable = &upnp.Error{Code: 701, Description: "Transition not available"}
+
+func (f *fakeRenderer) control(w http.ResponseWriter, r *http.Request) {
+	a, err := upnp.ReadAction(r)
+	if err != nil {
+		upnp.WriteError(w, upnp.ErrInvalidAction)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, a.Name)
+	var args []upnp.Arg
+	switch a.Name {
+	case "SetAVTransportURI":
+		f.uri, f.meta, f.state, f.time = a.Args["CurrentURI"], a.Args["CurrentURIMetaData"], "STOPPED", 0
+	case "Play":
+		if f.uri == "" {
+			upnp.WriteError(w, transitionNotAvailable)
+			return
+		}
+		f.state = "PLAYING"
+	case "Pause":
+		if f.state != "PLAYING" {
+			upnp.WriteError(w, transitionNotAvailable)
+			return
+		}
+		f.state = "PAUSED_PLAYBACK"
+	case "Stop":
+		if f.uri == "" {
+			upnp.WriteError(w, transitionNotAvailable)
+			return
+		}
+		f.state, f.time = "STOPPED", 0
+	case "Seek":
+		if a.Args["Unit"] != "REL_TIME" {
+			upnp.WriteError(w, upnp.ErrInvalidArgs)
+			return
+		}
+		f.time = upnp.ParseTime(a.Args["Target"])
+	case "GetTransportInfo":
+		args = []upnp.Arg{{Name: "CurrentTransportState", Value: f.state}}
+	case "GetPositionInfo":
+		args = []upnp.Arg{
+			{Name: "TrackDuration", Value: upnp.FormatTime(f.duration)},
+			{Name: "RelTime", Value: upnp.FormatTime(f.time)},
+		}
+	case "GetVolume":
+		if f.mute {
+			upnp.WriteError(w, upnp.ErrInvalidAction)
+			return
+		}
+		args = []upnp.Arg{{Name: "CurrentVolume", Value: strconv.Itoa(f.volume)}}
+	case "SetVolume":
+		f.volume, _ = strconv.Atoi(a.Args["DesiredVolume"])
+	default:
+		upnp.WriteError(w, upnp.ErrInvalidAction)
+		return
+	}
+	upnp.WriteResponse(w, a, args...)
+}
+
+// set changes the playback of the renderer, as if someone used its remote
+// or the file played on.
+func (f *fakeRenderer) set(state string, time int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state, f.time = state, time
+}
+
+// do calls fn with the renderer locked, to look at or change its fields.
+func (f *fakeRenderer) do(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func (f *fakeRenderer) playing() (state, uri string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state, f.uri
+}
+
+func movie(name string) Item {
+	return Item{
+		Path:   "Movies/" + name,
+		URL:    "file:///media/Movies/" + name,
+		Title:  strings.TrimSuffix(name, ".mkv"),
+		Stream: "/download?file=Movies%2F" + name,
+	}
+}
+
+func status(t *testing.T, p Player) *Status {
+	t.Helper()
+	s, err := p.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDLNAPlay(t *testing.T) {
+	f, srv := newFakeRenderer(t)
+	p := NewDLNA(srv.URL+"/description.xml", 8080)
+	if err := p.Load(movie("a.mkv"), true); err != nil {
+		t.Fatal(err)
+	}
+	state, uri := f.playing()
+	if state != "PLAYING" || uri != "http://127.0.0.1:8080/download?file=Movies%2Fa.mkv" {
+		t.Errorf("renderer is %s %s", state, uri)
+	}
+	f.do(func() {
+		if !strings.Contains(f.meta, "<dc:title>a</dc:title>") || !strings.Contains(f.meta, "http-get:*:video/x-matroska:") {
+			t.Errorf("metadata %s", f.meta)
+		}
+		if calls := strings.Join(f.calls, " "); calls != "Stop SetAVTransportURI Play" {
+			t.Errorf("calls %s", calls)
+		}
+		f.duration = 600
+	})
+	s := status(t, p)
+	if s.State != "playing" || s.Current != 1 || s.Filename != "a.mkv" || s.Title != "a" || s.Length != 600 {
+		t.Errorf("status %+v", s)
+	}
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(t, p); s.State != "paused" {
+		t.Errorf("paused, state %s", s.State)
+	}
+	if err := p.Toggle(); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(t, p); s.State != "playing" {
+		t.Errorf("toggled, state %s", s.State)
+	}
+
+	if err := p.Seek(90, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Seek(-30, true); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(t, p); s.Time != 60 || s.Position != 0.1 {
+		t.Errorf("seeked to %d, position %v, want 60", s.Time, s.Position)
+	}
+
+	if err := p.SetVolume(128, false); err != nil {
+		t.Fatal(err)
+	}
+	f.do(func() {
+		if f.volume != 50 {
+			t.Errorf("renderer volume %d, want 50", f.volume)
+		}
+	})
+	if err := p.SetVolume(26, true); err != nil {
+		t.Fatal(err)
+	}
+	s = status(t, p)
+	f.do(func() {
+		if f.volume != 60 || s.Volume != 153 {
+			t.Errorf("renderer volume %d, status %d, want 60 and 153", f.volume, s.Volume)
+		}
+	})
+
+	// A renderer that won't tell its volume still plays.
+	f.do(func() { f.mute = true })
+	if s := status(t, p); s.State != "playing" || s.Volume != -1 {
+		t.Errorf("without the volume, status %+v", s)
+	}
+
+	if err := p.SetRate(1); err != nil {
+		t.Error(err)
+	}
+	if err := p.SetRate(2); err == nil {
+		t.Error("renderer changed speed")
+	}
+	if err := p.SelectSubtitleTrack(1); err == nil {
+		t.Error("renderer picked a subtitle track")
+	}
+}
+
+func TestDLNAAdvance(t *testing.T) {
+	f, srv := newFakeRenderer(t)
+	f.do(func() { f.duration = 600 })
+	p := NewDLNA(srv.URL+"/description.xml", 8080)
+	for i, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		if err := p.Load(movie(name), i == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file stopped at its end is followed by the next one.
+	f.set("PLAYING", 598)
+	status(t, p)
+	f.set("STOPPED", 0)
+	if s := status(t, p); s.State != "playing" || s.Current != 2 || s.Filename != "b.mkv" {
+		t.Errorf("after the end of a, status %+v", s)
+	}
+	if _, uri := f.playing(); !strings.HasSuffix(uri, "Movies%2Fb.mkv") {
+		t.Errorf("renderer plays %s, want b", uri)
+	}
+
+	// One stopped halfway through stays stopped.
+	f.set("PLAYING", 300)
+	status(t, p)
+	f.set("STOPPED", 0)
+	if s := status(t, p); s.State != "stopped" || s.Current != 2 {
+		t.Errorf("after stopping b, status %+v", s)
+	}
+	if s := status(t, p); s.State != "stopped" {
+		t.Errorf("stopped b, then status %+v", s)
+	}
+
+	if err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, uri := f.playing(); !strings.HasSuffix(uri, "Movies%2Fc.mkv") {
+		t.Errorf("renderer plays %s after next, want c", uri)
+	}
+	entries, err := p.Playlist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !entries[2].Current {
+		t.Errorf("playlist %+v", entries)
+	}
+
+	// The last file ending leaves the renderer stopped.
+	f.set("PLAYING", 599)
+	status(t, p)
+	f.set("STOPPED", 0)
+	if s := status(t, p); s.State != "stopped" || s.Current != 3 {
+		t.Errorf("after the last file, status %+v", s)
+	}
+}
+
+func TestDLNAClear(t *testing.T) {
+	f, srv := newFakeRenderer(t)
+	p := NewDLNA(srv.URL+"/description.xml", 8080)
+	// Renderers refuse to stop with nothing loaded, which clearing ignores.
+	if err := p.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load(movie("a.mkv"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := f.playing(); state != "STOPPED" {
+		t.Errorf("renderer is %s after clearing", state)
+	}
+	if s := status(t, p); s.Current != -1 {
+		t.Errorf("status %+v after clearing", s)
+	}
+}
+
+// TestDLNAFindUnlocked checks the player answers while the renderer is
+// looked for.
+func TestDLNAFindUnlocked(t *testing.T) {
+	f, srv := newFakeRenderer(t)
+	f.hold = make(chan struct{})
+	p := NewDLNA(srv.URL+"/description.xml", 8080)
+	loaded := make(chan error)
+	go func() {
+		loaded <- p.Load(movie("a.mkv"), true)
+	}()
+	<-f.hold
+	if entries, err := p.Playlist(); err != nil || len(entries) != 0 {
+		t.Errorf("playlist %v, %v while looking for the renderer", entries, err)
+	}
+	<-f.hold
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := f.playing(); state != "PLAYING" {
+		t.Errorf("renderer is %s", state)
+	}
+}
+
+func TestDLNANotFound(t *testing.T) {
+	_, srv := newFakeRenderer(t)
+	p := NewDLNA(srv.URL+"/missing.xml", 8080)
+	if _, err := p.Status(); err == nil {
+		t.Error("got the status of a renderer that isn't there")
+	}
+}
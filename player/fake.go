@@ -0,0 +1,269 @@
+package player
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// FakeLength is how long every file lasts in the fake player.
+const FakeLength = 10 * time.Minute
+
+// Fake is a Player that shows nothing, for trying pilot out or developing it
+// without a TV. Files play in real time, one after the other, and are all
+// FakeLength long. Build using NewFake().
+type Fake struct {
+	mu      sync.Mutex
+	entries []Entry
+	nextID  int
+	current int
+	state   string
+	// offset is how far into the current file playback was when it last
+	// started, stopped or changed speed, started when that was.
+	offset  time.Duration
+	started time.Time
+	volume  int
+	rate    float64
+}
+
+// NewFake returns a fake player with an empty playlist.
+func NewFake() *Fake {
+	return &Fake{nextID: 1, current: -1, state: "stopped", volume: 256, rate: 1}
+}
+
+// position returns how far into the current file playback is, moving on to
+// the next file if the current one ended.
+func (p *Fake) position() time.Duration {
+	for p.state == "playing" {
+		pos := p.offset + time.Duration(float64(time.Since(p.started))*p.rate)
+		if pos < FakeLength {
+			return pos
+		}
+		p.started = p.started.Add(time.Duration(float64(FakeLength-p.offset) / p.rate))
+		p.offset = 0
+		if p.current+1 >= len(p.entries) {
+			p.state = "stopped"
+			break
+		}
+		p.current++
+	}
+	return p.offset
+}
+
+func (p *Fake) start(i int, offset time.Duration) {
+	p.current, p.state, p.offset, p.started = i, "playing", offset, time.Now()
+}
+
+func (p *Fake) find(id int) int {
+	for i, e := range p.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Fake) Status() (*Status, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := p.position()
+	s := &Status{
+		State:          p.state,
+		Volume:         p.volume,
+		Rate:           p.rate,
+		AudioTracks:    []Track{},
+		SubtitleTracks: []Track{},
+		VideoTracks:    []Track{},
+		Current:        -1,
+	}
+	if p.current >= 0 {
+		e := p.entries[p.current]
+		s.Title, s.Filename, s.Current = e.Title, path.Base(e.URL), e.ID
+		s.Time, s.Length = int(pos.Seconds()), int(FakeLength.Seconds())
+		s.Position = float64(pos) / float64(FakeLength)
+	}
+	return s, nil
+}
+
+func (p *Fake) Playlist() ([]Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position()
+	entries := make([]Entry, len(p.entries))
+	for i, e := range p.entries {
+		e.Current = i == p.current
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+func (p *Fake) Load(item Item, play bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position()
+	p.entries = append(p.entries, Entry{
+		ID:       p.nextID,
+		URL:      item.URL,
+		Title:    item.Title,
+		Duration: int(FakeLength.Seconds()),
+	})
+	p.nextID++
+	if play {
+		p.start(len(p.entries)-1, 0)
+	}
+	return nil
+}
+
+func (p *Fake) Remove(id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position()
+	i := p.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	p.entries = append(p.entries[:i], p.entries[i+1:]...)
+	switch {
+	case i == p.current:
+		p.current, p.state, p.offset = -1, "stopped", 0
+	case i < p.current:
+		p.current--
+	}
+	return nil
+}
+
+func (p *Fake) Clear() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries, p.current, p.state, p.offset = nil, -1, "stopped", 0
+	return nil
+}
+
+func (p *Fake) PlayEntry(id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	p.start(i, 0)
+	return nil
+}
+
+func (p *Fake) Play() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := p.position()
+	switch {
+	case p.state == "paused":
+		p.start(p.current, pos)
+	case p.state == "stopped" && p.current >= 0:
+		p.start(p.current, 0)
+	case p.state == "stopped" && len(p.entries) > 0:
+		p.start(0, 0)
+	}
+	return nil
+}
+
+func (p *Fake) Pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pos := p.position(); p.state == "playing" {
+		p.state, p.offset = "paused", pos
+	}
+	return nil
+}
+
+func (p *Fake) Toggle() error {
+	p.mu.Lock()
+	p.position()
+	playing := p.state == "playing"
+	p.mu.Unlock()
+	if playing {
+		return p.Pause()
+	}
+	return p.Play()
+}
+
+func (p *Fake) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state, p.offset = "stopped", 0
+	return nil
+}
+
+func (p *Fake) Next() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position()
+	if p.current+1 < len(p.entries) {
+		p.start(p.current+1, 0)
+	}
+	return nil
+}
+
+func (p *Fake) Previous() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position()
+	if p.current > 0 {
+		p.start(p.current-1, 0)
+	}
+	return nil
+}
+
+func (p *Fake) Seek(seconds int, relative bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pos := p.position()
+	if p.current < 0 {
+		return nil
+	}
+	to := time.Duration(seconds) * time.Second
+	if relative {
+		to += pos
+	}
+	if to < 0 {
+		to = 0
+	}
+	if to > FakeLength {
+		to = FakeLength
+	}
+	p.offset, p.started = to, time.Now()
+	return nil
+}
+
+func (p *Fake) SetVolume(volume int, relative bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if relative {
+		volume += p.volume
+	}
+	if volume < 0 {
+		volume = 0
+	}
+	if volume > 512 {
+		volume = 512
+	}
+	p.volume = volume
+	return nil
+}
+
+func (p *Fake) SetRate(rate float64) error {
+	if rate <= 0 {
+		return fmt.Errorf("invalid rate %v", rate)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pos := p.position(); p.state == "playing" {
+		p.offset, p.started = pos, time.Now()
+	}
+	p.rate = rate
+	return nil
+}
+
+func (p *Fake) SelectAudioTrack(id int) error    { return nil }
+func (p *Fake) SelectSubtitleTrack(id int) error { return nil }
+func (p *Fake) SelectVideoTrack(id int) error    { return nil }
+func (p *Fake) SelectChapter(id int) error       { return nil }
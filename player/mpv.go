@@ -0,0 +1,399 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"time"
+)
+
+// MPVTimeout bounds every command sent to mpv.
+var MPVTimeout = 5 * time.Second
+
+// errUnavailable is mpv's answer for properties that have no value right
+// now, e.g. the duration when nothing is playing.
+var errUnavailable = errors.New("property unavailable")
+
+// MPV is a Player for mpv's JSON IPC, enabled by starting mpv with
+// --input-ipc-server=socket. Build using NewMPV().
+type MPV struct {
+	socket string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// NewMPV returns a Player for the mpv listening on the Unix socket at socket.
+// It connects on first use, and again after any error.
+func NewMPV(socket string) *MPV {
+	return &MPV{socket: socket}
+}
+
+type mpvResponse struct {
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	RequestID int             `json:"request_id"`
+	Event     string          `json:"event"`
+}
+
+// command runs an mpv command and returns its result.
+func (p *MPV) command(args ...interface{}) (json.RawMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		conn, err := net.DialTimeout("unix", p.socket, MPVTimeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conn, p.reader = conn, bufio.NewReader(conn)
+	}
+	p.nextID++
+	req, err := json.Marshal(&struct {
+		Command   []interface{} `json:"command"`
+		RequestID int           `json:"request_id"`
+	}{args, p.nextID})
+	if err != nil {
+		return nil, err
+	}
+	p.conn.SetDeadline(time.Now().Add(MPVTimeout))
+	if _, err := p.conn.Write(append(req, '\n')); err != nil {
+		p.close()
+		return nil, err
+	}
+	for {
+		line, err := p.reader.ReadBytes('\n')
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		var resp mpvResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			p.close()
+			return nil, fmt.Errorf("bad response from mpv: %v", err)
+		}
+		// Events are sent on the same socket, whenever they happen.
+		if resp.Event != "" || resp.RequestID != p.nextID {
+			continue
+		}
+		switch resp.Error {
+		case "success":
+			return resp.Data, nil
+		case errUnavailable.Error():
+			return nil, errUnavailable
+		}
+		return nil, fmt.Errorf("mpv %v: %s", args[0], resp.Error)
+	}
+}
+
+func (p *MPV) close() {
+	p.conn.Close()
+	p.conn, p.reader = nil, nil
+}
+
+// get reads property into v, leaving v alone if it is unavailable.
+func (p *MPV) get(property string, v interface{}) error {
+	data, err := p.command("get_property", property)
+	if err == errUnavailable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (p *MPV) set(property string, value interface{}) error {
+	_, err := p.command("set_property", property, value)
+	return err
+}
+
+type mpvTrack struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"`
+	Codec string `json:"codec"`
+	Lang  string `json:"lang"`
+}
+
+type mpvEntry struct {
+	ID       int    `json:"id"`
+	Filename string `json:"filename"`
+	Title    string `json:"title"`
+	Current  bool   `json:"current"`
+}
+
+// playlist returns mpv's playlist. Entries of mpv older than 0.33 have no
+// ID, so their position stands in for it.
+func (p *MPV) playlist() ([]mpvEntry, error) {
+	var entries []mpvEntry
+	if err := p.get("playlist", &entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].ID == 0 {
+			entries[i].ID = i + 1
+		}
+	}
+	return entries, nil
+}
+
+// index returns the playlist position of the entry with the given ID.
+func (p *MPV) index(id int) (int, error) {
+	entries, err := p.playlist()
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		if e.ID == id {
+			return i, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (p *MPV) Status() (*Status, error) {
+	var (
+		idle, paused      bool
+		pos, length       float64
+		percent, volume   float64
+		speed             float64
+		chapter, chapters int
+		title, filename   string
+		tracks            []mpvTrack
+	)
+	for property, v := range map[string]interface{}{
+		"idle-active": &idle,
+		"pause":       &paused,
+		"time-pos":    &pos,
+		"duration":    &length,
+		"percent-pos": &percent,
+		"volume":      &volume,
+		"speed":       &speed,
+		"chapter":     &chapter,
+		"chapters":    &chapters,
+		"media-title": &title,
+		"filename":    &filename,
+		"track-list":  &tracks,
+	} {
+		if err := p.get(property, v); err != nil {
+			return nil, err
+		}
+	}
+	s := &Status{
+		State:          "playing",
+		Filename:       filename,
+		Time:           int(pos),
+		Length:         int(length),
+		Position:       percent / 100,
+		Volume:         int(volume * 256 / 100),
+		Rate:           speed,
+		Chapter:        chapter,
+		Chapters:       chapters,
+		AudioTracks:    []Track{},
+		SubtitleTracks: []Track{},
+		VideoTracks:    []Track{},
+		Current:        -1,
+	}
+	// mpv falls back to the filename for the title.
+	if title != filename {
+		s.Title = title
+	}
+	switch {
+	case idle:
+		s.State = "stopped"
+	case paused:
+		s.State = "paused"
+	}
+	for _, t := range tracks {
+		track := Track{ID: t.ID, Codec: t.Codec, Language: t.Lang}
+		switch t.Type {
+		case "audio":
+			track.Type = "Audio"
+			s.AudioTracks = append(s.AudioTracks, track)
+		case "sub":
+			track.Type = "Subtitle"
+			s.SubtitleTracks = append(s.SubtitleTracks, track)
+		case "video":
+			track.Type = "Video"
+			s.VideoTracks = append(s.VideoTracks, track)
+		}
+	}
+	if !idle {
+		entries, err := p.playlist()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Current {
+				s.Current = e.ID
+			}
+		}
+	}
+	return s, nil
+}
+
+func (p *MPV) Playlist() ([]Entry, error) {
+	entries, err := p.playlist()
+	if err != nil {
+		return nil, err
+	}
+	var idle bool
+	if err := p.get("idle-active", &idle); err != nil {
+		return nil, err
+	}
+	list := []Entry{}
+	for _, e := range entries {
+		title := e.Title
+		if title == "" {
+			title = path.Base(e.Filename)
+		}
+		list = append(list, Entry{
+			ID:      e.ID,
+			URL:     e.Filename,
+			Title:   title,
+			Current: e.Current && !idle,
+		})
+	}
+	return list, nil
+}
+
+func (p *MPV) Load(item Item, play bool) error {
+	if _, err := p.command("loadfile", item.URL, "append"); err != nil {
+		return err
+	}
+	if !play {
+		return nil
+	}
+	var count int
+	if err := p.get("playlist-count", &count); err != nil {
+		return err
+	}
+	if err := p.set("playlist-pos", count-1); err != nil {
+		return err
+	}
+	return p.set("pause", false)
+}
+
+func (p *MPV) Remove(id int) error {
+	i, err := p.index(id)
+	if err != nil {
+		return err
+	}
+	_, err = p.command("playlist-remove", i)
+	return err
+}
+
+func (p *MPV) Clear() error {
+	// Without keep-playlist, stopping also empties the playlist.
+	_, err := p.command("stop")
+	return err
+}
+
+func (p *MPV) PlayEntry(id int) error {
+	i, err := p.index(id)
+	if err != nil {
+		return err
+	}
+	if err := p.set("playlist-pos", i); err != nil {
+		return err
+	}
+	return p.set("pause", false)
+}
+
+func (p *MPV) Play() error {
+	var idle bool
+	if err := p.get("idle-active", &idle); err != nil {
+		return err
+	}
+	if idle {
+		entries, err := p.playlist()
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		i := 0
+		for j, e := range entries {
+			if e.Current {
+				i = j
+			}
+		}
+		if err := p.set("playlist-pos", i); err != nil {
+			return err
+		}
+	}
+	return p.set("pause", false)
+}
+
+func (p *MPV) Pause() error {
+	return p.set("pause", true)
+}
+
+func (p *MPV) Toggle() error {
+	_, err := p.command("cycle", "pause")
+	return err
+}
+
+func (p *MPV) Stop() error {
+	_, err := p.command("stop", "keep-playlist")
+	return err
+}
+
+func (p *MPV) Next() error {
+	_, err := p.command("playlist-next")
+	return err
+}
+
+func (p *MPV) Previous() error {
+	_, err := p.command("playlist-prev")
+	return err
+}
+
+func (p *MPV) Seek(seconds int, relative bool) error {
+	flag := "absolute"
+	if relative {
+		flag = "relative"
+	}
+	_, err := p.command("seek", seconds, flag)
+	return err
+}
+
+func (p *MPV) SetVolume(volume int, relative bool) error {
+	percent := float64(volume) * 100 / 256
+	if relative {
+		_, err := p.command("add", "volume", percent)
+		return err
+	}
+	return p.set("volume", percent)
+}
+
+func (p *MPV) SetRate(rate float64) error {
+	return p.set("speed", rate)
+}
+
+func (p *MPV) SelectAudioTrack(id int) error {
+	return p.set("aid", track(id))
+}
+
+func (p *MPV) SelectSubtitleTrack(id int) error {
+	return p.set("sid", track(id))
+}
+
+func (p *MPV) SelectVideoTrack(id int) error {
+	return p.set("vid", track(id))
+}
+
+func (p *MPV) SelectChapter(id int) error {
+	return p.set("chapter", id)
+}
+
+// track returns the value mpv takes to select track id, where -1 is none.
+func track(id int) interface{} {
+	if id < 0 {
+		return "no"
+	}
+	return id
+}
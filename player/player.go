@@ -0,0 +1,118 @@
+/*
+Package player drives the program that plays video on the TV. Player is
+implemented for VLC's HTTP interface, for mpv's JSON IPC, for DLNA renderers
+over UPnP and by a fake player that needs no TV.
+*/
+package player
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for playlist entries that don't exist.
+var ErrNotFound = errors.New("no such playlist entry")
+
+// Item is a file to play. URL is what the player opens, Path is the file
+// relative to the library root, if it is in the library.
+type Item struct {
+	Path  string `json:"path"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// Stream is where pilot serves the file over HTTP, as a path on its own
+	// address, for players on other machines.
+	Stream string `json:"stream,omitempty"`
+}
+
+// Track is an audio, video or subtitle stream of the playing file.
+type Track struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// Status is what the player is doing. State is "playing", "paused" or
+// "stopped", Title is the title in the file's metadata, if any, times are in
+// seconds and Volume goes from 0 to 512, 256 being the file's own loudness, or
+// is -1 if the player can't tell. Current is the ID of the playlist entry being
+// played, or -1.
+type Status struct {
+	State          string
+	Title          string
+	Filename       string
+	Time           int
+	Length         int
+	Position       float64
+	Volume         int
+	Rate           float64
+	Chapter        int
+	Chapters       int
+	AudioTracks    []Track
+	SubtitleTracks []Track
+	VideoTracks    []Track
+	Current        int
+}
+
+// Elapsed returns how far into the file playback is. It is more precise than
+// Time when the player reports a position.
+func (s *Status) Elapsed() time.Duration {
+	if s.Length > 0 && s.Position > 0 {
+		return time.Duration(s.Position * float64(s.Length) * float64(time.Second))
+	}
+	return time.Duration(s.Time) * time.Second
+}
+
+// Entry is an item in the player's playlist.
+type Entry struct {
+	ID       int
+	URL      string
+	Title    string
+	Duration int
+	Current  bool
+}
+
+// Player plays a playlist of files on the TV.
+type Player interface {
+	Status() (*Status, error)
+	Playlist() ([]Entry, error)
+
+	// Load adds item to the end of the playlist, starting it if play is set.
+	Load(item Item, play bool) error
+	// Remove removes the playlist entry with the given ID.
+	Remove(id int) error
+	// Clear empties the playlist.
+	Clear() error
+	// PlayEntry plays the playlist entry with the given ID.
+	PlayEntry(id int) error
+
+	// Play resumes playback, or starts the current entry if stopped.
+	Play() error
+	// Pause pauses playback, doing nothing if it is already paused.
+	Pause() error
+	// Toggle pauses or resumes playback.
+	Toggle() error
+	Stop() error
+	Next() error
+	Previous() error
+
+	// Seek jumps to seconds into the file, or by seconds if relative.
+	Seek(seconds int, relative bool) error
+	// SetVolume sets the volume, or changes it by volume if relative.
+	SetVolume(volume int, relative bool) error
+	// SetRate sets the playback speed, 1 being normal.
+	SetRate(rate float64) error
+	SelectAudioTrack(id int) error
+	SelectSubtitleTrack(id int) error
+	SelectVideoTrack(id int) error
+	SelectChapter(id int) error
+}
+
+// ParseValue parses a seek or volume value: a number, or a signed one to
+// change the current value by.
+func ParseValue(value string) (n int, relative bool, err error) {
+	n, err = strconv.Atoi(value)
+	return n, strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-"), err
+}
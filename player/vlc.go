@@ -0,0 +1,176 @@
+package player
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/etherealmachine/pilot/vlcctrl"
+)
+
+// VLC is a Player for VLC's HTTP interface. Build using NewVLC().
+type VLC struct {
+	vlc vlcctrl.VLC
+}
+
+// NewVLC returns a Player for the VLC HTTP interface at host and port.
+func NewVLC(host string, port int, password string) *VLC {
+	vlc, _ := vlcctrl.NewVLC(host, port, password)
+	return &VLC{vlc}
+}
+
+func (p *VLC) Status() (*Status, error) {
+	status, err := p.vlc.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{
+		State:          status.State,
+		Time:           status.Time,
+		Length:         status.Length,
+		Position:       status.Position,
+		Volume:         status.Volume,
+		Rate:           status.Rate,
+		Chapter:        status.Information.Chapter,
+		Chapters:       len(status.Information.Chapters),
+		AudioTracks:    []Track{},
+		SubtitleTracks: []Track{},
+		VideoTracks:    []Track{},
+		Current:        status.CurrentPlID,
+	}
+	for name, category := range status.Information.Category {
+		if name == "meta" {
+			s.Title, s.Filename = category.Title, category.Filename
+			continue
+		}
+		var id int
+		if _, err := fmt.Sscanf(name, "Stream %d", &id); err != nil {
+			continue
+		}
+		track := Track{ID: id, Type: category.Type, Codec: category.Codec, Language: category.Language}
+		switch category.Type {
+		case "Audio":
+			s.AudioTracks = append(s.AudioTracks, track)
+		case "Subtitle":
+			s.SubtitleTracks = append(s.SubtitleTracks, track)
+		case "Video":
+			s.VideoTracks = append(s.VideoTracks, track)
+		}
+	}
+	for _, tracks := range [][]Track{s.AudioTracks, s.SubtitleTracks, s.VideoTracks} {
+		sort.Slice(tracks, func(i, j int) bool {
+			return tracks[i].ID < tracks[j].ID
+		})
+	}
+	return s, nil
+}
+
+func (p *VLC) Playlist() ([]Entry, error) {
+	playlist, err := p.vlc.Playlist()
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	if len(playlist.Children) < 1 {
+		return entries, nil
+	}
+	var walk func(node vlcctrl.Node)
+	walk = func(node vlcctrl.Node) {
+		if node.Type == "leaf" {
+			id, _ := strconv.Atoi(node.ID)
+			entries = append(entries, Entry{
+				ID:       id,
+				URL:      node.URI,
+				Title:    node.Name,
+				Duration: node.Duration,
+				Current:  node.Current != "",
+			})
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	// The first child is the playlist, the second the media library.
+	walk(playlist.Children[0])
+	return entries, nil
+}
+
+func (p *VLC) Load(item Item, play bool) error {
+	if play {
+		return p.vlc.AddStart(url.QueryEscape(item.URL))
+	}
+	return p.vlc.Add(url.QueryEscape(item.URL))
+}
+
+func (p *VLC) Remove(id int) error {
+	return p.vlc.Delete(id)
+}
+
+func (p *VLC) Clear() error {
+	return p.vlc.EmptyPlaylist()
+}
+
+func (p *VLC) PlayEntry(id int) error {
+	return p.vlc.Play(id)
+}
+
+func (p *VLC) Play() error {
+	return p.vlc.Play()
+}
+
+func (p *VLC) Pause() error {
+	return p.vlc.ForcePause()
+}
+
+func (p *VLC) Toggle() error {
+	return p.vlc.Pause()
+}
+
+func (p *VLC) Stop() error {
+	return p.vlc.Stop()
+}
+
+func (p *VLC) Next() error {
+	return p.vlc.Next()
+}
+
+func (p *VLC) Previous() error {
+	return p.vlc.Previous()
+}
+
+func (p *VLC) Seek(seconds int, relative bool) error {
+	return p.vlc.Seek(url.QueryEscape(value(seconds, relative)))
+}
+
+func (p *VLC) SetVolume(volume int, relative bool) error {
+	return p.vlc.Volume(url.QueryEscape(value(volume, relative)))
+}
+
+func (p *VLC) SetRate(rate float64) error {
+	return p.vlc.PlaybackRate(rate)
+}
+
+func (p *VLC) SelectAudioTrack(id int) error {
+	return p.vlc.SelectAudioTrack(id)
+}
+
+func (p *VLC) SelectSubtitleTrack(id int) error {
+	return p.vlc.SelectSubtitleTrack(id)
+}
+
+func (p *VLC) SelectVideoTrack(id int) error {
+	return p.vlc.SelectVideoTrack(id)
+}
+
+func (p *VLC) SelectChapter(id int) error {
+	return p.vlc.SelectChapter(id)
+}
+
+// value formats n the way VLC takes seek and volume values.
+func value(n int, relative bool) string {
+	if relative {
+		return fmt.Sprintf("%+d", n)
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,140 @@
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Track is an entry of a playlist file. Location is a URI or a path, Duration
+// is in seconds, or -1 if unknown.
+type Track struct {
+	Location string
+	Title    string
+	Duration int
+}
+
+// WriteM3U writes an extended M3U playlist, as VLC saves them.
+func WriteM3U(w io.Writer, name string, tracks []Track) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	fmt.Fprintf(b, "#PLAYLIST:%s\n", name)
+	for _, t := range tracks {
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", t.Duration, t.Title)
+		fmt.Fprintln(b, t.Location)
+	}
+	return b.Flush()
+}
+
+// ReadM3U reads a plain or extended M3U playlist, returning its name if it
+// has one.
+func ReadM3U(r io.Reader) (string, []Track, error) {
+	var (
+		name   string
+		tracks []Track
+		next   = Track{Duration: -1}
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			// The duration can be followed by attributes, e.g. #EXTINF:-1 tvg-id="x",Title
+			if fields := strings.Fields(info[0]); len(fields) > 0 {
+				if d, err := strconv.Atoi(fields[0]); err == nil {
+					next.Duration = d
+				}
+			}
+			if len(info) == 2 {
+				next.Title = strings.TrimSpace(info[1])
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			next.Location = line
+			tracks = append(tracks, next)
+			next = Track{Duration: -1}
+		}
+	}
+	return name, tracks, scanner.Err()
+}
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Duration int    `xml:"duration,omitempty"`
+}
+
+// WriteXSPF writes an XSPF playlist.
+func WriteXSPF(w io.Writer, name string, tracks []Track) error {
+	p := xspfPlaylist{XMLNS: xspfNamespace, Version: "1", Title: name}
+	for _, t := range tracks {
+		xt := xspfTrack{Location: t.Location, Title: t.Title}
+		if t.Duration > 0 {
+			// XSPF durations are in milliseconds.
+			xt.Duration = t.Duration * 1000
+		}
+		p.Tracks = append(p.Tracks, xt)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&p); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadXSPF reads an XSPF playlist, returning its title.
+func ReadXSPF(r io.Reader) (string, []Track, error) {
+	var p xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&p); err != nil {
+		return "", nil, fmt.Errorf("error reading XSPF playlist: %v", err)
+	}
+	var tracks []Track
+	for _, t := range p.Tracks {
+		duration := -1
+		if t.Duration > 0 {
+			duration = t.Duration / 1000
+		}
+		tracks = append(tracks, Track{
+			Location: strings.TrimSpace(t.Location),
+			Title:    t.Title,
+			Duration: duration,
+		})
+	}
+	return p.Title, tracks, nil
+}
+
+// Read reads an M3U or XSPF playlist, telling them apart by their content.
+func Read(r io.Reader) (string, []Track, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("<")) {
+		return ReadXSPF(bytes.NewReader(data))
+	}
+	return ReadM3U(bytes.NewReader(data))
+}
@@ -0,0 +1,104 @@
+package playlist
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var tracks = []Track{
+	{Location: "file:///media/Movies/Alien%20%281979%29.mkv", Title: "Alien (1979)", Duration: 7020},
+	{Location: "file:///media/TV/Show/Season%201/Show%20-%20101%20-%20Pilot.mkv", Title: "Pilot", Duration: -1},
+	{Location: "/media/Movies/Heat.mkv", Title: "Heat, the director's cut", Duration: 10200},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []struct {
+		name  string
+		write func(*bytes.Buffer) error
+	}{
+		{"m3u", func(b *bytes.Buffer) error { return WriteM3U(b, "Friday night", tracks) }},
+		{"xspf", func(b *bytes.Buffer) error { return WriteXSPF(b, "Friday night", tracks) }},
+	} {
+		var b bytes.Buffer
+		if err := format.write(&b); err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+		name, got, err := Read(&b)
+		if err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+		if name != "Friday night" || !reflect.DeepEqual(got, tracks) {
+			t.Errorf("%s: read back %q %+v, want %+v", format.name, name, got, tracks)
+		}
+	}
+}
+
+func TestReadM3U(t *testing.T) {
+	m3u := "\ufeff#EXTM3U\r\n" +
+		"#EXTINF:123 tvg-id=\"x\",Alien\r\n" +
+		"/media/Movies/Alien.mkv\r\n" +
+		"\r\n" +
+		"# a comment\r\n" +
+		"Heat.mkv\r\n"
+	name, got, err := ReadM3U(strings.NewReader(m3u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Track{
+		{Location: "/media/Movies/Alien.mkv", Title: "Alien", Duration: 123},
+		{Location: "Heat.mkv", Duration: -1},
+	}
+	if name != "" || !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q %+v, want %+v", name, got, want)
+	}
+}
+
+// vlcXSPF is a playlist as VLC saves it.
+const vlcXSPF = `<?xml version="1.0" encoding="UTF-8"?>
+<playlist xmlns="http://xspf.org/ns/0/" xmlns:vlc="http://www.videolan.org/vlc/playlist/ns/0/" version="1">
+	<title>Playlist</title>
+	<trackList>
+		<track>
+			<location>file:///media/Movies/Alien%20%281979%29.mkv</location>
+			<title>Alien (1979)</title>
+			<duration>7020480</duration>
+			<extension application="http://www.videolan.org/vlc/playlist/0">
+				<vlc:id>0</vlc:id>
+			</extension>
+		</track>
+		<track>
+			<location>file:///media/TV/Caf%C3%A9/S01E01.mkv</location>
+			<extension application="http://www.videolan.org/vlc/playlist/0">
+				<vlc:id>1</vlc:id>
+				<vlc:option>start-time=30</vlc:option>
+			</extension>
+		</track>
+	</trackList>
+	<extension application="http://www.videolan.org/vlc/playlist/0">
+		<vlc:item tid="0"/>
+		<vlc:item tid="1"/>
+	</extension>
+</playlist>
+`
+
+func TestReadVLCXSPF(t *testing.T) {
+	name, got, err := Read(strings.NewReader(vlcXSPF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Playlist" || len(got) != 2 {
+		t.Fatalf("read %q %+v", name, got)
+	}
+	if got[0].Title != "Alien (1979)" || got[0].Duration != 7020 || got[1].Title != "" || got[1].Duration != -1 {
+		t.Errorf("tracks %+v", got)
+	}
+	for i, want := range []string{"/media/Movies/Alien (1979).mkv", "/media/TV/Café/S01E01.mkv"} {
+		u, err := url.Parse(got[i].Location)
+		if err != nil || u.Scheme != "file" || u.Path != want {
+			t.Errorf("location %q is not file://%s", got[i].Location, want)
+		}
+	}
+}
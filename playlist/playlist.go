@@ -0,0 +1,214 @@
+/*
+Package playlist stores named lists of library files and reads and writes them
+as M3U and XSPF files.
+*/
+package playlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for playlists that don't exist.
+var ErrNotFound = errors.New("no such playlist")
+
+// Playlist is a named list of library files, relative to the library root.
+type Playlist struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Files   []string  `json:"files"`
+	Updated time.Time `json:"updated"`
+}
+
+// Store holds every playlist, persisted to disk. Build using Open().
+type Store struct {
+	sync.RWMutex
+	Playlists map[int]*Playlist `json:"playlists"`
+	NextID    int               `json:"nextID"`
+
+	filename string
+	// save serializes saves, which share the temporary file and must write
+	// the playlists in the order they changed.
+	save sync.Mutex
+}
+
+// Open loads the playlists saved in filename, or returns an empty store if it
+// does not exist yet.
+func Open(filename string) (*Store, error) {
+	s := &Store{
+		Playlists: make(map[int]*Playlist),
+		NextID:    1,
+		filename:  filename,
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func clone(p *Playlist) *Playlist {
+	c := *p
+	c.Files = append([]string{}, p.Files...)
+	return &c
+}
+
+// List returns a copy of every playlist, sorted by name.
+func (s *Store) List() []*Playlist {
+	s.RLock()
+	defer s.RUnlock()
+	playlists := make([]*Playlist, 0, len(s.Playlists))
+	for _, p := range s.Playlists {
+		playlists = append(playlists, clone(p))
+	}
+	sort.Slice(playlists, func(i, j int) bool {
+		return strings.ToLower(playlists[i].Name) < strings.ToLower(playlists[j].Name)
+	})
+	return playlists
+}
+
+// Get returns a copy of playlist id.
+func (s *Store) Get(id int) (*Playlist, error) {
+	s.RLock()
+	defer s.RUnlock()
+	p, ok := s.Playlists[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(p), nil
+}
+
+func (s *Store) checkName(name string, id int) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("missing playlist name")
+	}
+	for _, p := range s.Playlists {
+		if p.ID != id && strings.EqualFold(p.Name, name) {
+			return fmt.Errorf("a playlist named %q already exists", p.Name)
+		}
+	}
+	return nil
+}
+
+// Create adds a new playlist holding files.
+func (s *Store) Create(name string, files ...string) (*Playlist, error) {
+	s.Lock()
+	if err := s.checkName(name, 0); err != nil {
+		s.Unlock()
+		return nil, err
+	}
+	p := &Playlist{
+		ID:      s.NextID,
+		Name:    strings.TrimSpace(name),
+		Files:   append([]string{}, files...),
+		Updated: time.Now(),
+	}
+	s.Playlists[p.ID] = p
+	s.NextID++
+	c := clone(p)
+	s.Unlock()
+	return c, s.Save()
+}
+
+// update runs f on playlist id under the lock, then saves the store.
+func (s *Store) update(id int, f func(p *Playlist) error) (*Playlist, error) {
+	s.Lock()
+	p, ok := s.Playlists[id]
+	if !ok {
+		s.Unlock()
+		return nil, ErrNotFound
+	}
+	if err := f(p); err != nil {
+		s.Unlock()
+		return nil, err
+	}
+	p.Updated = time.Now()
+	c := clone(p)
+	s.Unlock()
+	return c, s.Save()
+}
+
+// Rename changes the name of playlist id.
+func (s *Store) Rename(id int, name string) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		if err := s.checkName(name, id); err != nil {
+			return err
+		}
+		p.Name = strings.TrimSpace(name)
+		return nil
+	})
+}
+
+// Append adds files to the end of playlist id.
+func (s *Store) Append(id int, files ...string) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		p.Files = append(p.Files, files...)
+		return nil
+	})
+}
+
+// Remove deletes the entry at index i of playlist id.
+func (s *Store) Remove(id, i int) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		if i < 0 || i >= len(p.Files) {
+			return fmt.Errorf("no entry %d in playlist %q", i, p.Name)
+		}
+		p.Files = append(p.Files[:i], p.Files[i+1:]...)
+		return nil
+	})
+}
+
+// Move moves the entry at index from of playlist id to index to.
+func (s *Store) Move(id, from, to int) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		if from < 0 || from >= len(p.Files) || to < 0 || to >= len(p.Files) {
+			return fmt.Errorf("can't move entry %d to %d in playlist %q", from, to, p.Name)
+		}
+		file := p.Files[from]
+		p.Files = append(p.Files[:from], p.Files[from+1:]...)
+		p.Files = append(p.Files[:to], append([]string{file}, p.Files[to:]...)...)
+		return nil
+	})
+}
+
+// Delete removes playlist id.
+func (s *Store) Delete(id int) error {
+	s.Lock()
+	if _, ok := s.Playlists[id]; !ok {
+		s.Unlock()
+		return ErrNotFound
+	}
+	delete(s.Playlists, id)
+	s.Unlock()
+	return s.Save()
+}
+
+// Save writes the store to disk, replacing the previous copy atomically.
+func (s *Store) Save() error {
+	s.save.Lock()
+	defer s.save.Unlock()
+	s.RLock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := s.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
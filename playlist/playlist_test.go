@@ -0,0 +1,67 @@
+package playlist
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "playlists.json")
+	s, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.Create(" Friday night ", "a.mkv", "b.mkv", "c.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Friday night" {
+		t.Errorf("created %q", p.Name)
+	}
+	if _, err := s.Create("friday NIGHT"); err == nil {
+		t.Error("created two playlists of the same name")
+	}
+	other, err := s.Create("Saturday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rename(other.ID, "Friday Night"); err == nil {
+		t.Error("renamed a playlist to the name of another")
+	}
+	if _, err := s.Rename(p.ID, "FRIDAY NIGHT"); err != nil {
+		t.Errorf("renaming a playlist to itself: %v", err)
+	}
+
+	if _, err := s.Move(p.ID, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Move(p.ID, 0, 3); err == nil {
+		t.Error("moved an entry past the end")
+	}
+	if _, err := s.Remove(p.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Remove(p.ID, 2); err == nil {
+		t.Error("removed an entry past the end")
+	}
+	if err := s.Delete(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(other.ID); err != ErrNotFound {
+		t.Errorf("got a deleted playlist: %v", err)
+	}
+
+	// Everything was saved.
+	s, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlists := s.List()
+	if len(playlists) != 1 {
+		t.Fatalf("reopened %d playlists", len(playlists))
+	}
+	if got := playlists[0]; got.Name != "FRIDAY NIGHT" || !reflect.DeepEqual(got.Files, []string{"c.mkv", "b.mkv"}) {
+		t.Errorf("reopened %q %v", got.Name, got.Files)
+	}
+}
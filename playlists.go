@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/etherealmachine/pilot/playlist"
+)
+
+// importResult is the reply to a playlist import, listing the entries that
+// aren't in the library.
+type importResult struct {
+	*playlist.Playlist
+	Skipped []string `json:"skipped"`
+}
+
+func (s *server) apiPlaylists(r *http.Request, p []string) (interface{}, error) {
+	switch {
+	case len(p) == 0 && r.Method == http.MethodGet:
+		return s.Playlists.List(), nil
+	case len(p) == 0 && r.Method == http.MethodPost:
+		req, err := parseAPIRequest(r)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		var files []string
+		if req.File != "" {
+			if err := s.checkFile(req.File); err != nil {
+				return nil, err
+			}
+			files = append(files, req.File)
+		}
+		pl, err := s.Playlists.Create(req.Value, files...)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return pl, nil
+	case len(p) == 1 && p[0] == "import" && r.Method == http.MethodPost:
+		return s.importPlaylist(r)
+	case len(p) == 0:
+		return nil, errNotFound
+	}
+	id, err := strconv.Atoi(p[0])
+	if err != nil {
+		return nil, errNotFound
+	}
+	if _, err := s.Playlists.Get(id); err != nil {
+		return nil, errNotFound
+	}
+	req, err := parseAPIRequest(r)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	var pl *playlist.Playlist
+	switch {
+	case len(p) == 1 && r.Method == http.MethodGet:
+		pl, err = s.Playlists.Get(id)
+	case len(p) == 1 && r.Method == http.MethodDelete:
+		if err := s.Playlists.Delete(id); err != nil {
+			return nil, err
+		}
+		return s.Playlists.List(), nil
+	case len(p) == 2 && p[1] == "rename" && r.Method == http.MethodPost:
+		pl, err = s.Playlists.Rename(id, req.Value)
+	case len(p) == 2 && p[1] == "append" && r.Method == http.MethodPost:
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		pl, err = s.Playlists.Append(id, req.File)
+	case len(p) == 2 && p[1] == "play" && r.Method == http.MethodPost:
+		d, err := s.requestDevice(r)
+		if err != nil {
+			return nil, err
+		}
+		start, _ := strconv.Atoi(req.Value)
+		return s.PlayPlaylist(d, id, start)
+	case len(p) == 2 && r.Method == http.MethodDelete:
+		i, convErr := strconv.Atoi(p[1])
+		if convErr != nil {
+			return nil, errNotFound
+		}
+		pl, err = s.Playlists.Remove(id, i)
+	case len(p) == 3 && p[2] == "move" && r.Method == http.MethodPost:
+		i, convErr := strconv.Atoi(p[1])
+		if convErr != nil {
+			return nil, errNotFound
+		}
+		to, convErr := strconv.Atoi(req.Value)
+		if convErr != nil {
+			return nil, badRequest("invalid value %q", req.Value)
+		}
+		pl, err = s.Playlists.Move(id, i, to)
+	default:
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	return pl, nil
+}
+
+// PlayPlaylist replaces the queue of d with playlist id and starts playing
+// its entry at index start.
+func (s *server) PlayPlaylist(d *device, id, start int) (interface{}, error) {
+	pl, err := s.Playlists.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || start >= len(pl.Files) {
+		return nil, badRequest("no entry %d in playlist %q", start, pl.Name)
+	}
+	if !d.Monitor.Online() {
+		return nil, errOffline
+	}
+	if err := d.Queue.Replace(pl.Files...); err != nil {
+		return nil, err
+	}
+	if err := s.PlayQueueItem(d, start); err != nil {
+		return nil, err
+	}
+	return s.queueState(d), nil
+}
+
+// exportPlaylist writes playlist id as an M3U file, or XSPF with ?format=xspf,
+// pointing at the files on disk so VLC can open it.
+func (s *server) exportPlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	pl, err := s.Playlists.Get(n)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	var tracks []playlist.Track
+	for _, file := range pl.Files {
+		tracks = append(tracks, playlist.Track{
+			Location: s.fileURL(file),
+			Title:    titleize(file),
+			Duration: -1,
+		})
+	}
+	write, ext, contentType := playlist.WriteM3U, "m3u", "audio/x-mpegurl"
+	if r.FormValue("format") == "xspf" {
+		write, ext, contentType = playlist.WriteXSPF, "xspf", "application/xspf+xml"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", slugify(pl.Name)+"."+ext))
+	if err := write(w, pl.Name, tracks); err != nil {
+		log.Println(err)
+	}
+}
+
+// importPlaylist creates a playlist from an M3U or XSPF file, uploaded as the
+// "playlist" form file or sent as the request body.
+func (s *server) importPlaylist(r *http.Request) (interface{}, error) {
+	var (
+		body     io.Reader = r.Body
+		filename string
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, header, err := r.FormFile("playlist")
+		if err != nil {
+			return nil, badRequest("missing playlist file: %v", err)
+		}
+		defer f.Close()
+		body, filename = f, header.Filename
+	}
+	name, tracks, err := playlist.Read(body)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if value := r.FormValue("value"); value != "" {
+		name = value
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	result := &importResult{Skipped: []string{}}
+	var files []string
+	for _, t := range tracks {
+		if file := s.resolveLocation(t.Location); file != "" {
+			files = append(files, file)
+		} else {
+			result.Skipped = append(result.Skipped, t.Location)
+		}
+	}
+	result.Playlist, err = s.Playlists.Create(name, files...)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	return result, nil
+}
+
+// resolveLocation finds the library file a playlist entry points to: a file://
+// URI or absolute path under the root, a path relative to the root, or a
+// pilot download link. It returns an empty string if the file isn't indexed.
+func (s *server) resolveLocation(location string) string {
+	var file string
+	u, err := url.Parse(location)
+	switch {
+	case err == nil && (u.Scheme == "http" || u.Scheme == "https"):
+		if path.Base(u.Path) == "download" {
+			file = u.Query().Get("file")
+		}
+	case err == nil && u.Scheme == "file":
+		file = s.libraryPath(location)
+	case filepath.IsAbs(location):
+		file = s.libraryPath(location)
+	default:
+		file = filepath.Clean(location)
+	}
+	if file == "" || s.Library.Get(file) == nil {
+		return ""
+	}
+	return file
+}
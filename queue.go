@@ -0,0 +1,269 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// QueueItem is an entry in the play queue.
+type QueueItem struct {
+	Index   int    `json:"index"`
+	File    string `json:"file"`
+	Title   string `json:"title"`
+	Current bool   `json:"current"`
+}
+
+// QueueState is the play queue as returned by the API. Current is -1 if
+// nothing from the queue is playing.
+type QueueState struct {
+	Items   []QueueItem `json:"items"`
+	Current int         `json:"current"`
+}
+
+// pushQueue replaces the playlist of d with its queue from the current item
+// on. If play is set the current item starts playing, start seconds in.
+func (s *server) pushQueue(d *device, play bool, start int) error {
+	if !d.Monitor.Online() {
+		return errOffline
+	}
+	files, current := d.Queue.List()
+	if current < 0 {
+		current, play = 0, false
+	}
+	if err := d.player().Stop(); err != nil {
+		return err
+	}
+	if err := d.player().Clear(); err != nil {
+		return err
+	}
+	for i, file := range files[current:] {
+		if err := d.player().Load(s.item(file), i == 0 && play); err != nil {
+			return err
+		}
+	}
+	if play && start > 0 {
+		return s.seekWhenStarted(d, start)
+	}
+	return nil
+}
+
+// syncQueue makes the playlist of d match its queue after the current item,
+// without interrupting what is playing. A file taken off the queue while it
+// plays goes on to its end, followed by the new current item. While d is
+// offline it's synced once it comes back instead.
+func (s *server) syncQueue(d *device) error {
+	if !d.Monitor.Online() {
+		d.Monitor.markStale()
+		return nil
+	}
+	status, err := d.player().Status()
+	if err != nil {
+		return err
+	}
+	items, err := s.playlistItems(d)
+	if err != nil {
+		return err
+	}
+	var current *PlaylistItem
+	for i := range items {
+		if items[i].Current {
+			current = &items[i]
+		}
+	}
+	if status.State == "stopped" || current == nil {
+		return s.pushQueue(d, false, 0)
+	}
+	files, i := d.Queue.List()
+	upcoming := files[i+1:]
+	if i < 0 || current.File != files[i] {
+		if contains(files, current.File) {
+			return s.pushQueue(d, false, 0)
+		}
+		// The file playing was taken off the queue, the current item follows.
+		upcoming = nil
+		if i >= 0 {
+			upcoming = files[i:]
+		}
+	}
+	for _, item := range items {
+		if item.ID == current.ID {
+			continue
+		}
+		if err := d.player().Remove(item.ID); err != nil {
+			return err
+		}
+	}
+	for _, file := range upcoming {
+		if err := d.player().Load(s.item(file), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(files []string, file string) bool {
+	for _, f := range files {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+// PlayQueueItem starts playing the item at index i of the queue of d.
+func (s *server) PlayQueueItem(d *device, i int) error {
+	if !d.Monitor.Online() {
+		return errOffline
+	}
+	if err := d.Queue.SetCurrent(i); err != nil {
+		return badRequest("%v", err)
+	}
+	return s.pushQueue(d, true, 0)
+}
+
+// ClearTV stops playback and empties both the queue and the playlist of d.
+func (s *server) ClearTV(d *device) error {
+	if err := d.Queue.Clear(); err != nil {
+		return err
+	}
+	if !d.Monitor.Online() {
+		d.Monitor.markStale()
+		return nil
+	}
+	if err := d.player().Stop(); err != nil {
+		return err
+	}
+	return d.player().Clear()
+}
+
+// followQueue moves the queue on to file when the player starts playing it
+// by itself, e.g. at the end of the previous one.
+func (s *server) followQueue(d *device, file string) {
+	files, current := d.Queue.List()
+	if current >= 0 && files[current] == file {
+		return
+	}
+	// Prefer the first match after the current item, the queue may repeat files.
+	for _, i := range append(seq(current+1, len(files)), seq(0, current+1)...) {
+		if files[i] == file {
+			if err := d.Queue.SetCurrent(i); err != nil {
+				log.Printf("error saving play queue: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func seq(from, to int) []int {
+	var s []int
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+// restoreQueue gives the queue of d back to its player if it came back with
+// an empty playlist, e.g. after VLC restarted. Playback resumes where it was if
+// the player was playing before. If the queue was edited while the player was
+// offline, the player's playlist is synced with it.
+func (s *server) restoreQueue(d *device) error {
+	stale, wasPlaying := d.Monitor.reconnected()
+	if !stale && len(d.Queue.Upcoming()) == 0 && d.Queue.Playing() == "" {
+		return nil
+	}
+	items, err := s.playlistItems(d)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		if stale {
+			return s.syncQueue(d)
+		}
+		return nil
+	}
+	playing := d.Queue.Playing()
+	log.Printf("the playlist of %s is empty, restoring its play queue", d.Name)
+	return s.pushQueue(d, wasPlaying, s.Progress.Get(playing).Resume())
+}
+
+func (s *server) queueState(d *device) *QueueState {
+	files, current := d.Queue.List()
+	state := &QueueState{Items: []QueueItem{}, Current: current}
+	for i, file := range files {
+		state.Items = append(state.Items, QueueItem{
+			Index:   i,
+			File:    file,
+			Title:   titleize(file),
+			Current: i == current,
+		})
+	}
+	return state
+}
+
+func (s *server) apiQueue(d *device, r *http.Request, path []string) (interface{}, error) {
+	if r.Method == http.MethodGet && len(path) == 0 {
+		return s.queueState(d), nil
+	}
+	req, err := parseAPIRequest(r)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	switch {
+	case len(path) == 0 && r.Method == http.MethodPost:
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		if err := d.Queue.Add(req.File); err != nil {
+			return nil, err
+		}
+		if req.Play {
+			files, _ := d.Queue.List()
+			err = s.PlayQueueItem(d, len(files)-1)
+		} else {
+			err = s.syncQueue(d)
+		}
+	case len(path) == 0 && r.Method == http.MethodDelete:
+		err = s.ClearTV(d)
+	case len(path) == 1 && path[0] == "next" && r.Method == http.MethodPost:
+		if err := s.checkFile(req.File); err != nil {
+			return nil, err
+		}
+		if err := d.Queue.PlayNext(req.File); err != nil {
+			return nil, err
+		}
+		err = s.syncQueue(d)
+	default:
+		if len(path) == 0 {
+			return nil, errNotFound
+		}
+		i, convErr := strconv.Atoi(path[0])
+		if convErr != nil {
+			return nil, errNotFound
+		}
+		switch {
+		case len(path) == 1 && r.Method == http.MethodDelete:
+			if err := d.Queue.Remove(i); err != nil {
+				return nil, badRequest("%v", err)
+			}
+			err = s.syncQueue(d)
+		case len(path) == 2 && path[1] == "move" && r.Method == http.MethodPost:
+			to, convErr := strconv.Atoi(req.Value)
+			if convErr != nil {
+				return nil, badRequest("invalid value %q", req.Value)
+			}
+			if err := d.Queue.Move(i, to); err != nil {
+				return nil, badRequest("%v", err)
+			}
+			err = s.syncQueue(d)
+		case len(path) == 2 && path[1] == "play" && r.Method == http.MethodPost:
+			err = s.PlayQueueItem(d, i)
+		default:
+			return nil, errNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.queueState(d), nil
+}
@@ -0,0 +1,184 @@
+/*
+Package queue keeps the list of files pilot plays on the TV, independently of
+the player's own playlist.
+*/
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Queue is an ordered list of library files, persisted to disk. Current is the
+// index of the file playing, or -1 if nothing from the queue is. Build using Open().
+type Queue struct {
+	sync.RWMutex
+	Items   []string `json:"items"`
+	Current int      `json:"current"`
+
+	filename string
+	// save serializes saves, which share the temporary file and must write
+	// the queue in the order it changed.
+	save sync.Mutex
+}
+
+// Open loads the queue saved in filename, or returns an empty queue if it does
+// not exist yet.
+func Open(filename string) (*Queue, error) {
+	q := &Queue{Current: -1, filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, q); err != nil {
+		return nil, err
+	}
+	if q.Current < -1 || q.Current >= len(q.Items) {
+		q.Current = -1
+	}
+	return q, nil
+}
+
+// List returns a copy of the items and the index of the current one.
+func (q *Queue) List() ([]string, int) {
+	q.RLock()
+	defer q.RUnlock()
+	return append([]string{}, q.Items...), q.Current
+}
+
+// Playing returns the current file, or an empty string.
+func (q *Queue) Playing() string {
+	q.RLock()
+	defer q.RUnlock()
+	if q.Current < 0 {
+		return ""
+	}
+	return q.Items[q.Current]
+}
+
+// Upcoming returns the files after the current one.
+func (q *Queue) Upcoming() []string {
+	q.RLock()
+	defer q.RUnlock()
+	return append([]string{}, q.Items[q.Current+1:]...)
+}
+
+// Replace empties the queue and fills it with files, the first one current.
+func (q *Queue) Replace(files ...string) error {
+	q.Lock()
+	q.Items = append([]string{}, files...)
+	q.Current = 0
+	if len(files) == 0 {
+		q.Current = -1
+	}
+	q.Unlock()
+	return q.Save()
+}
+
+// Add appends files to the end of the queue.
+func (q *Queue) Add(files ...string) error {
+	q.Lock()
+	q.Items = append(q.Items, files...)
+	q.Unlock()
+	return q.Save()
+}
+
+// PlayNext inserts files right after the current one.
+func (q *Queue) PlayNext(files ...string) error {
+	q.Lock()
+	at := q.Current + 1
+	items := append([]string{}, q.Items[:at]...)
+	items = append(items, files...)
+	q.Items = append(items, q.Items[at:]...)
+	q.Unlock()
+	return q.Save()
+}
+
+// Remove deletes the item at index i. Removing the current item makes the
+// one after it current, as it plays next, or nothing if it was the last.
+func (q *Queue) Remove(i int) error {
+	q.Lock()
+	if i < 0 || i >= len(q.Items) {
+		q.Unlock()
+		return fmt.Errorf("no item %d in the queue", i)
+	}
+	q.Items = append(q.Items[:i], q.Items[i+1:]...)
+	switch {
+	case i < q.Current:
+		q.Current--
+	case i == q.Current && i == len(q.Items):
+		q.Current = -1
+	}
+	q.Unlock()
+	return q.Save()
+}
+
+// Move moves the item at index from to index to, shifting the ones between.
+func (q *Queue) Move(from, to int) error {
+	q.Lock()
+	if from < 0 || from >= len(q.Items) || to < 0 || to >= len(q.Items) {
+		q.Unlock()
+		return fmt.Errorf("can't move item %d to %d in a queue of %d", from, to, len(q.Items))
+	}
+	item := q.Items[from]
+	q.Items = append(q.Items[:from], q.Items[from+1:]...)
+	q.Items = append(q.Items[:to], append([]string{item}, q.Items[to:]...)...)
+	switch {
+	case q.Current == from:
+		q.Current = to
+	case from < q.Current && to >= q.Current:
+		q.Current--
+	case from > q.Current && to <= q.Current:
+		q.Current++
+	}
+	q.Unlock()
+	return q.Save()
+}
+
+// Truncate removes every item after the current one.
+func (q *Queue) Truncate() error {
+	q.Lock()
+	q.Items = q.Items[:q.Current+1]
+	q.Unlock()
+	return q.Save()
+}
+
+// Clear empties the queue.
+func (q *Queue) Clear() error {
+	return q.Replace()
+}
+
+// SetCurrent marks the item at index i as the one playing, or nothing if i is -1.
+func (q *Queue) SetCurrent(i int) error {
+	q.Lock()
+	if i < -1 || i >= len(q.Items) {
+		q.Unlock()
+		return fmt.Errorf("no item %d in the queue", i)
+	}
+	q.Current = i
+	q.Unlock()
+	return q.Save()
+}
+
+// Save writes the queue to disk, replacing the previous copy atomically.
+func (q *Queue) Save() error {
+	q.save.Lock()
+	defer q.save.Unlock()
+	q.RLock()
+	data, err := json.MarshalIndent(q, "", "  ")
+	q.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := q.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.filename)
+}
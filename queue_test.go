@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSyncQueue(t *testing.T) {
+	s := newTestServer(t, "TV")
+	d := s.devices[0]
+	alien, heat := "Movies/Alien (1979).mkv", "Movies/Heat (1995).mkv"
+	pilot := "TV/Show/Season 1/Show - 101 - Pilot.mkv"
+	if err := s.PlayOnTV(d, alien, 0); err != nil {
+		t.Fatal(err)
+	}
+	before, err := d.player().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding to the queue adds to the playlist, leaving the file playing.
+	if err := d.Queue.Add(heat, pilot); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, current := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{alien, heat, pilot}) || current != alien {
+		t.Errorf("playlist %v playing %q", files, current)
+	}
+	status, err := d.player().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "playing" || status.Current != before.Current {
+		t.Errorf("syncing interrupted playback: %+v", status)
+	}
+
+	if err := d.Queue.Move(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{alien, pilot, heat}) {
+		t.Errorf("after moving, playlist %v", files)
+	}
+
+	// Taking the file playing off the queue leaves it playing, followed by
+	// the next one.
+	if err := d.Queue.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, current := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{alien, pilot, heat}) || current != alien {
+		t.Errorf("after removing alien, playlist %v playing %q", files, current)
+	}
+	if status, err := d.player().Status(); err != nil || status.State != "playing" || status.Current != before.Current {
+		t.Errorf("removing the file playing interrupted it: %+v, %v", status, err)
+	}
+	if playing := d.Queue.Playing(); playing != pilot {
+		t.Errorf("queue at %q after removing alien, want %q", playing, pilot)
+	}
+	if err := d.player().Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, current := d.Queue.List(); !reflect.DeepEqual(files, []string{pilot, heat}) || current != 0 {
+		t.Errorf("after alien, queue %v at %d", files, current)
+	}
+
+	// Once stopped, the player gets the whole queue back from the current
+	// file on.
+	if err := d.player().Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{pilot, heat}) {
+		t.Errorf("after stopping, playlist %v", files)
+	}
+
+	// Playing a queued file pushes the queue from it on.
+	if err := s.PlayQueueItem(d, 1); err != nil {
+		t.Fatal(err)
+	}
+	if files, current := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{heat}) || current != heat {
+		t.Errorf("after playing heat, playlist %v playing %q", files, current)
+	}
+
+	if err := s.ClearTV(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := playlistFiles(t, s, d); len(files) != 0 {
+		t.Errorf("after clearing, playlist %v", files)
+	}
+	if files, current := d.Queue.List(); len(files) != 0 || current != -1 {
+		t.Errorf("after clearing, queue %v at %d", files, current)
+	}
+}
+
+// TestSyncQueueOffline edits the queue while the player is away, which syncs
+// it once back.
+func TestSyncQueueOffline(t *testing.T) {
+	s := newTestServer(t, "TV")
+	d := s.devices[0]
+	alien, heat := "Movies/Alien (1979).mkv", "Movies/Heat (1995).mkv"
+	if err := s.PlayOnTV(d, alien, 0); err != nil {
+		t.Fatal(err)
+	}
+	d.Monitor.offline()
+	if err := d.Queue.Add(heat); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{alien}) {
+		t.Errorf("synced the playlist of an offline TV: %v", files)
+	}
+	if err := s.refresh(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.restoreQueue(d); err != nil {
+		t.Fatal(err)
+	}
+	if files, current := playlistFiles(t, s, d); !reflect.DeepEqual(files, []string{alien, heat}) || current != alien {
+		t.Errorf("once back, playlist %v playing %q", files, current)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/etherealmachine/pilot/library"
+)
+
+// searchLimit is the default number of search results.
+const searchLimit = 50
+
+// indexSearch rebuilds the search index, which takes a while on big libraries,
+// so it's done in the background after every change and searches keep using
+// the previous index until it's ready.
+func (s *server) indexSearch() {
+	s.indexing.Lock()
+	defer s.indexing.Unlock()
+	search := library.NewSearch(s.Library.List(), s.libraries())
+	s.Lock()
+	s.search = search
+	s.Unlock()
+}
+
+func (s *server) searchIndex() *library.Search {
+	s.RLock()
+	search := s.search
+	s.RUnlock()
+	if search == nil {
+		s.indexSearch()
+		return s.searchIndex()
+	}
+	return search
+}
+
+func (s *server) apiSearch(r *http.Request) (interface{}, error) {
+	query := r.FormValue("q")
+	if query == "" {
+		return nil, badRequest("missing q")
+	}
+	limit := searchLimit
+	if value := r.FormValue("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, badRequest("invalid limit %q", value)
+		}
+		limit = n
+	}
+	results := s.searchIndex().Query(query, limit)
+	if results == nil {
+		results = []library.SearchResult{}
+	}
+	return &struct {
+		Query   string                 `json:"query"`
+		Results []library.SearchResult `json:"results"`
+	}{query, results}, nil
+}
+
+type SearchTemplateParams struct {
+	Playing string
+	Query   string
+	Results []library.SearchResult
+}
+
+func (s *server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := &SearchTemplateParams{
+		Playing: s.CurrentlyPlaying(s.currentDevice(r)),
+		Query:   r.FormValue("q"),
+	}
+	if params.Query != "" {
+		params.Results = s.searchIndex().Query(params.Query, searchLimit)
+	}
+	if err := s.Templates["search.html"].Execute(w, params); err != nil {
+		log.Println(err)
+	}
+}
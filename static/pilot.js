@@ -0,0 +1,70 @@
+// Keeps the now playing bar in sync with the player status pushed on /events.
+// Other scripts can listen for the "pilot:status" event on document.
+(function() {
+	function formatTime(seconds) {
+		var h = Math.floor(seconds / 3600);
+		var m = Math.floor(seconds / 60) % 60;
+		var s = seconds % 60;
+		var mm = (h > 0 && m < 10 ? "0" : "") + m;
+		return (h > 0 ? h + ":" : "") + mm + ":" + (s < 10 ? "0" : "") + s;
+	}
+	window.pilotFormatTime = formatTime;
+
+	document.querySelectorAll("button[data-file][data-watched]").forEach(function(button) {
+		button.addEventListener("click", function(e) {
+			e.stopPropagation();
+			var body = new URLSearchParams();
+			body.append("file", button.dataset.file);
+			body.append("value", button.dataset.watched);
+			fetch("/api/v1/watched", {method: "POST", body: body}).then(function() {
+				window.location.reload();
+			});
+		});
+	});
+
+	document.querySelectorAll("button[data-file][data-queue]").forEach(function(button) {
+		button.addEventListener("click", function(e) {
+			e.stopPropagation();
+			var body = new URLSearchParams();
+			body.append("file", button.dataset.file);
+			var path = button.dataset.queue == "next" ? "/api/v1/queue/next" : "/api/v1/queue";
+			fetch(path, {method: "POST", body: body}).then(function(resp) {
+				if (resp.ok) {
+					button.textContent = "Queued";
+					button.disabled = true;
+				}
+			});
+		});
+	});
+
+	document.querySelectorAll("button[data-file][data-playlist]").forEach(function(button) {
+		button.addEventListener("click", function(e) {
+			e.stopPropagation();
+			var body = new URLSearchParams();
+			body.append("file", button.dataset.file);
+			fetch("/api/v1/playlists/" + button.dataset.playlist + "/append", {method: "POST", body: body}).then(function(resp) {
+				if (resp.ok) {
+					button.textContent = "✓ " + button.textContent;
+					button.disabled = true;
+				}
+			});
+		});
+	});
+
+	if (!window.EventSource) {
+		return;
+	}
+	var bar = document.getElementById("now-playing");
+	new EventSource("/events").addEventListener("status", function(e) {
+		var status = JSON.parse(e.data);
+		if (bar) {
+			bar.classList.toggle("d-none", status.online && !status.title);
+			bar.querySelector(".title").textContent = status.online ? status.title || "" : status.device + " offline";
+			bar.querySelector(".time").textContent = status.length > 0 ?
+				formatTime(status.time) + " / " + formatTime(status.length) : "";
+			bar.querySelector(".progress-bar").style.width = (status.position * 100) + "%";
+			bar.querySelector(".state").textContent = !status.online ? "⚠" : status.state == "paused" ? "⏸" : "▶";
+		}
+		document.dispatchEvent(new CustomEvent("pilot:status", {detail: status}));
+	});
+})();
@@ -0,0 +1,63 @@
+// Drives the /playlists page through the playlists API, reloading the page
+// after every change.
+(function() {
+	var error = document.getElementById("playlist-error");
+
+	function call(method, path, body) {
+		return fetch("/api/v1/playlists/" + path, {method: method, body: body}).then(function(resp) {
+			return resp.json().then(function(result) {
+				if (result && result.error) {
+					throw new Error(result.error);
+				}
+				return result;
+			});
+		}).catch(function(err) {
+			error.textContent = err.message;
+			error.classList.remove("d-none");
+			throw err;
+		});
+	}
+
+	document.querySelectorAll("#playlists button[data-path]").forEach(function(button) {
+		button.addEventListener("click", function() {
+			var body = new URLSearchParams();
+			if (button.dataset.prompt) {
+				var name = window.prompt(button.dataset.prompt, button.dataset.name);
+				if (!name) {
+					return;
+				}
+				body.append("value", name);
+			} else if (button.dataset.value) {
+				body.append("value", button.dataset.value);
+			}
+			if (button.dataset.confirm && !window.confirm(button.dataset.confirm)) {
+				return;
+			}
+			var path = button.dataset.path;
+			call(button.dataset.method || "POST", path, body).then(function() {
+				if (path.endsWith("/play")) {
+					window.location = "/cast";
+				} else {
+					window.location.reload();
+				}
+			});
+		});
+	});
+
+	document.getElementById("playlist-create").addEventListener("submit", function(e) {
+		e.preventDefault();
+		call("POST", "", new URLSearchParams(new FormData(e.target))).then(function() {
+			window.location.reload();
+		});
+	});
+
+	document.getElementById("playlist-import").addEventListener("submit", function(e) {
+		e.preventDefault();
+		call("POST", "import", new FormData(e.target)).then(function(result) {
+			if (result.skipped.length > 0) {
+				window.alert("Not in the library, skipped:\n" + result.skipped.join("\n"));
+			}
+			window.location.reload();
+		});
+	});
+})();
@@ -0,0 +1,189 @@
+// Drives the remote control page on /cast through the player API, and keeps
+// it in sync with the status pushed on /events.
+(function() {
+	var $ = function(id) { return document.getElementById(id); };
+	var seek = $("remote-seek");
+	var volume = $("remote-volume");
+	var seeking = false;
+
+	function call(method, path, params) {
+		var body = new URLSearchParams();
+		for (var key in params) {
+			body.append(key, params[key]);
+		}
+		var init = {method: method};
+		if (method != "GET") {
+			init.body = body;
+		}
+		return fetch("/api/v1/" + path, init).then(function(resp) {
+			return resp.json().then(function(result) {
+				if (result.error) {
+					throw new Error(result.error);
+				}
+				$("remote-error").classList.add("d-none");
+				return result;
+			});
+		}).catch(function(err) {
+			$("remote-error").textContent = err.message;
+			$("remote-error").classList.remove("d-none");
+		});
+	}
+
+	function command(name, params) {
+		return call("POST", "player/" + name, params);
+	}
+
+	function button(label, title, onclick) {
+		var b = document.createElement("button");
+		b.className = "btn btn-sm btn-link p-0 ms-2";
+		b.textContent = label;
+		b.title = title;
+		b.addEventListener("click", function(e) {
+			e.stopPropagation();
+			onclick();
+		});
+		return b;
+	}
+
+	function showQueue(queue) {
+		if (!queue) {
+			return;
+		}
+		var list = $("remote-queue");
+		list.innerHTML = "";
+		queue.items.forEach(function(item) {
+			var li = document.createElement("li");
+			li.className = "list-group-item d-flex align-items-center" + (item.current ? " active" : "");
+			li.style.cursor = "pointer";
+			li.title = "Play now";
+			li.addEventListener("click", function() {
+				call("POST", "queue/" + item.index + "/play").then(showQueue);
+			});
+			var title = document.createElement("span");
+			title.className = "flex-grow-1 text-truncate";
+			title.textContent = item.title;
+			li.appendChild(title);
+			if (item.index > 0) {
+				li.appendChild(button("▲", "Move up", function() {
+					call("POST", "queue/" + item.index + "/move", {value: item.index - 1}).then(showQueue);
+				}));
+			}
+			if (item.index < queue.items.length - 1) {
+				li.appendChild(button("▼", "Move down", function() {
+					call("POST", "queue/" + item.index + "/move", {value: item.index + 1}).then(showQueue);
+				}));
+			}
+			li.appendChild(button("✕", "Remove", function() {
+				call("DELETE", "queue/" + item.index).then(showQueue);
+			}));
+			list.appendChild(li);
+		});
+		if (queue.items.length == 0) {
+			var empty = document.createElement("li");
+			empty.className = "list-group-item text-muted";
+			empty.textContent = "The queue is empty";
+			list.appendChild(empty);
+		}
+	}
+
+	function loadQueue() {
+		call("GET", "queue").then(showQueue);
+	}
+
+	function options(select, items, selected) {
+		var value = select.value;
+		select.innerHTML = "";
+		items.forEach(function(item) {
+			var option = document.createElement("option");
+			option.value = item.value;
+			option.textContent = item.label;
+			select.appendChild(option);
+		});
+		select.value = selected !== undefined ? selected : value;
+		select.disabled = items.length < 2;
+	}
+
+	function trackLabel(track) {
+		return [track.language, track.codec].filter(Boolean).join(" - ") || "Track " + track.id;
+	}
+
+	document.querySelectorAll("#remote button[data-command]").forEach(function(button) {
+		button.addEventListener("click", function() {
+			var params = button.dataset.value ? {value: button.dataset.value} : {};
+			command(button.dataset.command, params);
+		});
+	});
+	document.querySelectorAll("#remote select[data-command]").forEach(function(select) {
+		select.addEventListener("change", function() {
+			command(select.dataset.command, {id: select.value});
+		});
+	});
+	seek.addEventListener("input", function() {
+		seeking = true;
+		$("remote-time").textContent = pilotFormatTime(parseInt(seek.value));
+	});
+	seek.addEventListener("change", function() {
+		command("seek", {value: seek.value}).then(function() {
+			seeking = false;
+		});
+	});
+	volume.addEventListener("change", function() {
+		command("volume", {value: volume.value});
+	});
+	$("remote-queue-clear").addEventListener("click", function() {
+		call("DELETE", "queue").then(showQueue);
+	});
+	loadQueue();
+
+	var chapters = 0;
+	var audioTracks = "", subtitleTracks = "";
+	var file;
+	document.addEventListener("pilot:status", function(e) {
+		var status = e.detail;
+		$("remote-offline").classList.toggle("d-none", status.online);
+		document.querySelectorAll("#remote button[data-command], #remote-seek, #remote-volume").forEach(function(control) {
+			control.disabled = !status.online;
+		});
+		if (status.file != file) {
+			file = status.file;
+			loadQueue();
+		}
+		$("remote-title").textContent = status.title || "Nothing playing";
+		$("remote-toggle").textContent = status.state == "playing" ? "⏸" : "▶";
+		$("remote-length").textContent = pilotFormatTime(status.length);
+		seek.max = status.length;
+		if (!seeking) {
+			seek.value = status.time;
+			$("remote-time").textContent = pilotFormatTime(status.time);
+		}
+		// Some TVs don't tell their volume.
+		volume.disabled = !status.online || status.volume < 0;
+		if (document.activeElement != volume && status.volume >= 0) {
+			volume.value = status.volume;
+		}
+		var audio = JSON.stringify(status.audioTracks);
+		if (audio != audioTracks) {
+			audioTracks = audio;
+			options($("remote-audio"), status.audioTracks.map(function(track) {
+				return {value: track.id, label: trackLabel(track)};
+			}));
+		}
+		var subtitles = JSON.stringify(status.subtitleTracks);
+		if (subtitles != subtitleTracks) {
+			subtitleTracks = subtitles;
+			options($("remote-subtitle"), [{value: -1, label: "Off"}].concat(status.subtitleTracks.map(function(track) {
+				return {value: track.id, label: trackLabel(track)};
+			})));
+		}
+		if (status.chapters != chapters) {
+			chapters = status.chapters;
+			var items = [];
+			for (var i = 0; i < chapters; i++) {
+				items.push({value: i, label: "Chapter " + (i + 1)});
+			}
+			options($("remote-chapter"), items, status.chapter);
+		} else if (document.activeElement != $("remote-chapter")) {
+			$("remote-chapter").value = status.chapter;
+		}
+	});
+})();
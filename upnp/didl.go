@@ -0,0 +1,106 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Classes of the objects pilot lists.
+const (
+	FolderClass = "object.container.storageFolder"
+	VideoClass  = "object.item.videoItem"
+	MovieClass  = "object.item.videoItem.movie"
+)
+
+// DIDL is a DIDL-Lite document, the metadata of content directory objects.
+// Its elements are written with the dc and upnp prefixes spelled out, as
+// many TVs don't understand them otherwise.
+type DIDL struct {
+	XMLName    xml.Name    `xml:"DIDL-Lite"`
+	Xmlns      string      `xml:"xmlns,attr"`
+	XmlnsDC    string      `xml:"xmlns:dc,attr"`
+	XmlnsUPnP  string      `xml:"xmlns:upnp,attr"`
+	Containers []Container `xml:"container"`
+	Items      []Item      `xml:"item"`
+}
+
+// Container is a folder of a content directory.
+type Container struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+}
+
+// Item is a file of a content directory.
+type Item struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+	Date       string `xml:"dc:date,omitempty"`
+	Res        []Res  `xml:"res"`
+}
+
+// Res is where the content of an item can be fetched from.
+type Res struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// Marshal returns the document as a string, as a Result argument wants it.
+func (d *DIDL) Marshal() (string, error) {
+	d.Xmlns = "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"
+	d.XmlnsDC = "http://purl.org/dc/elements/1.1/"
+	d.XmlnsUPnP = "urn:schemas-upnp-org:metadata-1-0/upnp/"
+	for i := range d.Containers {
+		d.Containers[i].Restricted = 1
+	}
+	for i := range d.Items {
+		d.Items[i].Restricted = 1
+	}
+	data, err := xml.Marshal(d)
+	return string(data), err
+}
+
+// videoTypes are the MIME types of the video files mime doesn't know
+// everywhere.
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".mpg":  "video/mpeg",
+	".mov":  "video/quicktime",
+	".wmv":  "video/x-ms-wmv",
+	".webm": "video/webm",
+	".flv":  "video/x-flv",
+	".3gp":  "video/3gpp",
+}
+
+// MimeType returns the MIME type of a video file.
+func MimeType(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if t, ok := videoTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// ContentFeatures are the DLNA flags of a file served over HTTP: it can be
+// streamed, and seeked by byte range.
+const ContentFeatures = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+
+// ProtocolInfo returns the protocolInfo of a video file served over HTTP.
+func ProtocolInfo(filename string) string {
+	return "http-get:*:" + MimeType(filename) + ":" + ContentFeatures
+}
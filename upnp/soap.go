@@ -0,0 +1,158 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client makes the requests to other devices, which should answer quickly
+// on a LAN.
+var client = &http.Client{Timeout: 5 * time.Second}
+
+// Arg is an argument of an action, or a value it returns. Arguments are
+// ordered, as some devices insist on the order of their description.
+type Arg struct {
+	Name  string
+	Value string
+}
+
+// Action is a call of a SOAP action of a service.
+type Action struct {
+	Service string
+	Name    string
+	Args    map[string]string
+}
+
+type envelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// ReadAction reads the action called by r.
+func ReadAction(r *http.Request) (*Action, error) {
+	var env envelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&env); err != nil {
+		return nil, err
+	}
+	call := env.Body.Action
+	if call.XMLName.Local == "" {
+		return nil, errors.New("no action in the soap body")
+	}
+	a := &Action{
+		Service: call.XMLName.Space,
+		Name:    call.XMLName.Local,
+		Args:    make(map[string]string),
+	}
+	for _, arg := range call.Args {
+		a.Args[arg.XMLName.Local] = arg.Value
+	}
+	return a, nil
+}
+
+const envelopeStart = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
+
+const envelopeEnd = `</s:Body></s:Envelope>`
+
+// body returns a SOAP envelope around the call of, or answer to, action of
+// service with args.
+func body(service, action string, args []Arg) string {
+	var b strings.Builder
+	b.WriteString(envelopeStart)
+	fmt.Fprintf(&b, `<u:%s xmlns:u="%s">`, action, service)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg.Name)
+		xml.EscapeText(&b, []byte(arg.Value))
+		fmt.Fprintf(&b, "</%s>", arg.Name)
+	}
+	fmt.Fprintf(&b, "</u:%s>", action)
+	b.WriteString(envelopeEnd)
+	return b.String()
+}
+
+// WriteResponse answers a with the values it returns.
+func WriteResponse(w http.ResponseWriter, a *Action, args ...Arg) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	io.WriteString(w, body(a.Service, a.Name+"Response", args))
+}
+
+// WriteError answers an action with a SOAP fault for err, an Error or else
+// ErrActionFailed.
+func WriteError(w http.ResponseWriter, err error) {
+	var upnpErr *Error
+	if !errors.As(err, &upnpErr) {
+		upnpErr = ErrActionFailed
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	var b strings.Builder
+	b.WriteString(envelopeStart)
+	b.WriteString(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`)
+	fmt.Fprintf(&b, `<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>`, upnpErr.Code)
+	xml.EscapeText(&b, []byte(upnpErr.Description))
+	b.WriteString(`</errorDescription></UPnPError></detail></s:Fault>`)
+	b.WriteString(envelopeEnd)
+	io.WriteString(w, b.String())
+}
+
+type fault struct {
+	Body struct {
+		Fault struct {
+			Error struct {
+				Code        int    `xml:"errorCode"`
+				Description string `xml:"errorDescription"`
+			} `xml:"detail>UPnPError"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// Call calls action of service at controlURL and returns the values it
+// returns. A SOAP fault is returned as an *Error.
+func Call(controlURL, service, action string, args ...Arg) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodPost, controlURL, strings.NewReader(body(service, action, args)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	// Some devices only understand the header spelled like this.
+	req.Header["SOAPACTION"] = []string{fmt.Sprintf(`"%s#%s"`, service, action)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var f fault
+		if xml.Unmarshal(data, &f) == nil && f.Body.Fault.Error.Code != 0 {
+			return nil, &Error{f.Body.Fault.Error.Code, f.Body.Fault.Error.Description}
+		}
+		return nil, fmt.Errorf("%s: %s", action, resp.Status)
+	}
+	var env envelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%s: %v", action, err)
+	}
+	values := make(map[string]string)
+	for _, arg := range env.Body.Action.Args {
+		values[arg.XMLName.Local] = arg.Value
+	}
+	return values, nil
+}
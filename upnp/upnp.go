@@ -0,0 +1,175 @@
+/*
+Package upnp holds the UPnP pieces pilot speaks: device descriptions, SOAP
+actions, DIDL-Lite metadata and the service descriptions of a MediaServer,
+and a client for the services of others.
+*/
+package upnp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Device and service types of a MediaServer.
+const (
+	MediaServer       = "urn:schemas-upnp-org:device:MediaServer:1"
+	ContentDirectory  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	ConnectionManager = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// Device and service types of a MediaRenderer.
+const (
+	MediaRenderer    = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	AVTransport      = "urn:schemas-upnp-org:service:AVTransport:1"
+	RenderingControl = "urn:schemas-upnp-org:service:RenderingControl:1"
+)
+
+// Root is a UPnP device description.
+type Root struct {
+	XMLName     xml.Name    `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion SpecVersion `xml:"specVersion"`
+	URLBase     string      `xml:"URLBase,omitempty"`
+	Device      Device      `xml:"device"`
+}
+
+// SpecVersion is the UPnP version a description follows, 1.0 for pilot.
+type SpecVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+// Device describes a UPnP device, the services it offers and the devices
+// embedded in it.
+type Device struct {
+	DeviceType      string       `xml:"deviceType"`
+	FriendlyName    string       `xml:"friendlyName"`
+	Manufacturer    string       `xml:"manufacturer"`
+	ModelName       string       `xml:"modelName"`
+	UDN             string       `xml:"UDN"`
+	PresentationURL string       `xml:"presentationURL,omitempty"`
+	ServiceList     *ServiceList `xml:"serviceList,omitempty"`
+	DeviceList      *DeviceList  `xml:"deviceList,omitempty"`
+}
+
+// ServiceList and DeviceList wrap the services and embedded devices of a
+// device, whose lists are left out when empty.
+type ServiceList struct {
+	Services []Service `xml:"service"`
+}
+
+type DeviceList struct {
+	Devices []Device `xml:"device"`
+}
+
+// Service describes a service of a device, with URLs relative to the
+// description.
+type Service struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// Error is a UPnP error, sent back as a SOAP fault.
+type Error struct {
+	Code        int
+	Description string
+}
+
+// Errors the actions of pilot fail with.
+var (
+	ErrInvalidAction = &Error{401, "Invalid Action"}
+	ErrInvalidArgs   = &Error{402, "Invalid Args"}
+	ErrActionFailed  = &Error{501, "Action Failed"}
+	ErrNoSuchObject  = &Error{701, "No such object"}
+	ErrBadCriteria   = &Error{708, "Unsupported or invalid search criteria"}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+// ReadDescription fetches the device description at location.
+func ReadDescription(location string) (*Root, error) {
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	root := new(Root)
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(root); err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	if root.URLBase == "" {
+		root.URLBase = location
+	}
+	return root, nil
+}
+
+// Find returns the first device of type deviceType, the root device or one
+// embedded in it, or nil.
+func (d *Device) Find(deviceType string) *Device {
+	if d.DeviceType == deviceType {
+		return d
+	}
+	if d.DeviceList != nil {
+		for i := range d.DeviceList.Devices {
+			if found := d.DeviceList.Devices[i].Find(deviceType); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// ControlURL returns the absolute control URL of the service of d of type
+// serviceType, described in root, or an empty string if d has no such
+// service.
+func (root *Root) ControlURL(d *Device, serviceType string) string {
+	if d.ServiceList == nil {
+		return ""
+	}
+	for _, svc := range d.ServiceList.Services {
+		if svc.ServiceType != serviceType {
+			continue
+		}
+		base, err := url.Parse(root.URLBase)
+		if err != nil {
+			return ""
+		}
+		ref, err := url.Parse(svc.ControlURL)
+		if err != nil {
+			return ""
+		}
+		return base.ResolveReference(ref).String()
+	}
+	return ""
+}
+
+// FormatTime formats seconds as H:MM:SS, the way UPnP writes durations.
+func FormatTime(seconds int) string {
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// ParseTime parses an H:MM:SS duration, which may have a fraction, into
+// seconds. Renderers that don't know say NOT_IMPLEMENTED, which is 0.
+func ParseTime(s string) int {
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return int(seconds)
+}
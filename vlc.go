@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// vlcStable is how long cvlc must run before a crash is no longer counted
+// towards the restart backoff.
+const vlcStable = time.Minute
+
+// vlcStopTimeout is how long cvlc gets to exit after SIGTERM before it is killed.
+const vlcStopTimeout = 5 * time.Second
+
+// vlcProcess runs cvlc with its HTTP interface and restarts it whenever it
+// exits, until Stop is called. Build using startVLC().
+type vlcProcess struct {
+	password string
+	// config is the vlcrc holding the password, which would be visible to
+	// every user on the command line.
+	config string
+	args   []string
+	env    []string
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startVLC starts cvlc listening on cfg.Host and cfg.Port with a random HTTP
+// password, and keeps it running.
+func startVLC(cfg VLCConfig) (*vlcProcess, error) {
+	command, err := exec.LookPath(cfg.Command)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	p := &vlcProcess{
+		password: hex.EncodeToString(secret),
+		env:      os.Environ(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if p.config, err = writeVLCConfig(p.password); err != nil {
+		return nil, err
+	}
+	p.args = []string{
+		command,
+		"--config", p.config,
+		"-I", "http",
+		"--http-host", cfg.Host,
+		"--http-port", strconv.Itoa(cfg.Port),
+	}
+	if cfg.Display != "" {
+		p.env = append(p.env, "DISPLAY="+cfg.Display)
+	}
+	if err := p.start(); err != nil {
+		os.Remove(p.config)
+		return nil, err
+	}
+	go p.supervise()
+	return p, nil
+}
+
+// writeVLCConfig writes a vlcrc with the HTTP password to a temporary file
+// only pilot's user can read, and returns its name.
+func writeVLCConfig(password string) (string, error) {
+	f, err := ioutil.TempFile("", "pilot-vlcrc")
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, "[lua]\nhttp-password=%s\n", password)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (p *vlcProcess) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopping {
+		return fmt.Errorf("vlc is shutting down")
+	}
+	r, w := io.Pipe()
+	cmd := &exec.Cmd{
+		Path:        p.args[0],
+		Args:        p.args,
+		Env:         p.env,
+		Stdout:      w,
+		Stderr:      w,
+		SysProcAttr: vlcProcAttr(),
+	}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return err
+	}
+	log.Printf("started vlc, pid %d", cmd.Process.Pid)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			log.Printf("vlc: %s", scanner.Text())
+		}
+	}()
+	p.cmd = cmd
+	return nil
+}
+
+// supervise waits for cvlc to exit and starts it again, waiting longer after
+// each crash in a row.
+func (p *vlcProcess) supervise() {
+	defer close(p.done)
+	delay := pollInterval
+	for {
+		p.mu.Lock()
+		cmd := p.cmd
+		p.mu.Unlock()
+		started := time.Now()
+		err := cmd.Wait()
+		cmd.Stdout.(*io.PipeWriter).Close()
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		if time.Since(started) > vlcStable {
+			delay = pollInterval
+		}
+		log.Printf("vlc exited (%v), restarting in %v", err, delay)
+		for {
+			select {
+			case <-time.After(delay):
+			case <-p.stop:
+				return
+			}
+			if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+			if err := p.start(); err != nil {
+				log.Printf("error starting vlc, retrying in %v: %v", delay, err)
+				continue
+			}
+			break
+		}
+	}
+}
+
+// Stop shuts cvlc down, killing it if it doesn't exit in time, and stops
+// restarting it.
+func (p *vlcProcess) Stop() {
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		<-p.done
+		return
+	}
+	p.stopping = true
+	close(p.stop)
+	cmd := p.cmd
+	p.mu.Unlock()
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(vlcStopTimeout):
+		log.Println("vlc didn't exit in time, killing it")
+		cmd.Process.Kill()
+		<-p.done
+	}
+	if err := os.Remove(p.config); err != nil {
+		log.Println(err)
+	}
+	log.Println("stopped vlc")
+}
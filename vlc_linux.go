@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// vlcProcAttr has cvlc terminated if pilot dies without stopping it, so a
+// restarted pilot doesn't find the port taken.
+func vlcProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "syscall"
+
+// vlcProcAttr returns nil, cvlc is only stopped when pilot exits cleanly.
+func vlcProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -74,7 +74,9 @@ type Information struct {
 	// TODO: Category definition might need to be updated/modified
 	Category map[string]struct {
 		Filename      string `json:"filename"`
+		Title         string `json:"title"`
 		Codec         string `json:"Codec"`
+		Language      string `json:"Language"`
 		Channels      string `json:"Channels"`
 		BitsPerSample string `json:"Bits_per_sample"`
 		Type          string `json:"Type"`
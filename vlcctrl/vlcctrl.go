@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Timeout bounds every request to VLC, so an unreachable instance fails fast
+// instead of hanging the caller.
+var Timeout = 10 * time.Second
+
 // VLC struct represents an http interface enabled VLC instance. Build using NewVLC()
 type VLC struct {
 	IP       string
@@ -34,7 +39,7 @@ func NewVLC(ip string, port int, password string) (VLC, error) {
 func (instance *VLC) RequestMaker(urlSegment string) (string, error) {
 
 	// Form a GET Request
-	client := &http.Client{}
+	client := &http.Client{Timeout: Timeout}
 	request, reqErr := http.NewRequest("GET", instance.BaseURL+urlSegment, nil)
 	if reqErr != nil {
 		return "", fmt.Errorf("http request error: %s\n", reqErr)